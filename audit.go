@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditFile is the default name of the audit log, in the output directory.
+const AuditFile = "ih-abstract-audit.jsonl"
+
+// auditPath gets the path of an audit log. The default audit log is in the output directory; other paths are used as given.
+func auditPath(name string, outputDir string) string {
+	if name == AuditFile {
+		return filepath.Join(outputDir, name)
+	}
+
+	return name
+}
+
+// AuditEntry is an audit log entry describing a run: who ran it, where, with which configuration and query, how many rows and patients it touched, which files it wrote, and the error that ended it, if it failed.
+// Prev is the hash of the previous entry, chaining entries so that deleted or edited entries are detectable.
+type AuditEntry struct {
+	Seq         int64             `json:"seq"`
+	Time        time.Time         `json:"time"`
+	Run         string            `json:"run"`
+	User        string            `json:"user"`
+	Host        string            `json:"host"`
+	Config      string            `json:"config"`
+	Fingerprint string            `json:"config-fingerprint"`
+	Input       Input             `json:"input"`
+	Parameters  map[string]string `json:"parameters"`
+	Complete    bool              `json:"complete"`
+	Rows        map[string]int64  `json:"rows"`
+	Patients    int64             `json:"patients"`
+	Outputs     map[string]Output `json:"outputs"`
+	Error       string            `json:"error,omitempty"`
+	Prev        string            `json:"prev"`
+}
+
+// auditRecord is a line of the audit log: an encoded entry and the hash of the encoded entry.
+type auditRecord struct {
+	Hash  string          `json:"hash"`
+	Entry json.RawMessage `json:"entry"`
+}
+
+// auditHash hashes an encoded audit log entry.
+func auditHash(entry []byte) string {
+	sum := sha256.Sum256(entry)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Patients counts the distinct patients of a run.
+type Patients struct {
+	idx int
+	ok  bool
+	ids map[string](struct{})
+	sync.Mutex
+}
+
+// NewPatients creates a patient counter for input data with a header. Patients are identified using the column of RecordID.
+func NewPatients(header []string) (p *Patients) {
+	p = &Patients{ids: make(map[string](struct{}))}
+
+	id, err := RecordID(header)
+	if err == nil {
+		p.idx, p.ok = headerParse(header)[id]
+	}
+
+	return p
+}
+
+// Add collects the patient identifier of an input record. Add does nothing if the counter is nil.
+func (p *Patients) Add(l []string) {
+	if p == nil || !p.ok || p.idx >= len(l) {
+		return
+	}
+
+	p.Lock()
+	p.ids[l[p.idx]] = struct{}{}
+	p.Unlock()
+}
+
+// N gets the number of distinct patients counted. N returns zero if the counter is nil.
+func (p *Patients) N() int64 {
+	if p == nil {
+		return 0
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return int64(len(p.ids))
+}
+
+// auditParameters gets the command line parameters of a run.
+func auditParameters(f flags) map[string]string {
+	return map[string]string{
+		"compress":        *f.compress,
+		"config":          *f.config,
+		"date-shift":      *f.dateShift,
+		"deterministic":   strconv.FormatBool(*f.deterministic),
+		"encrypt-to":      *f.encryptTo,
+		"identity":        *f.identity,
+		"keep-runs":       strconv.Itoa(*f.keepRuns),
+		"no-filter":       strconv.FormatBool(*f.noFilter),
+		"old":             *f.old,
+		"output-dir":      *f.outputDir,
+		"output-format":   *f.outputFormat,
+		"passphrase-file": *f.passphrase,
+		"pseudonym-key":   *f.pseudonymKey,
+		"runs":            strconv.FormatBool(*f.runs),
+		"scrub":           strconv.FormatBool(*f.scrub),
+		"sort-memory":     strconv.FormatInt(*f.sortMemory, 10),
+		"sql":             strconv.FormatBool(*f.sql),
+		"state":           *f.state,
+		"stdout":          *f.stdout,
+	}
+}
+
+// NewAuditEntry creates an audit log entry from a completed run manifest, the command line parameters of the run, the output directory of the run, and the number of patients it touched.
+// Output paths are absolute. The OS user and host are recorded, along with the path of the configuration file used, if any.
+func NewAuditEntry(m *Manifest, f flags, dir string, patients int64) (e AuditEntry) {
+	m.Lock()
+	defer m.Unlock()
+
+	e = AuditEntry{
+		Time:        time.Now().UTC(),
+		Run:         m.Run,
+		Fingerprint: m.Fingerprint,
+		Input:       m.Input,
+		Parameters:  auditParameters(f),
+		Complete:    m.Complete,
+		Rows:        make(map[string]int64),
+		Patients:    patients,
+		Outputs:     make(map[string]Output),
+	}
+
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	} else {
+		e.User = os.Getenv("USER")
+	}
+
+	e.Host, _ = os.Hostname()
+
+	config := *f.config
+	if config == "" {
+		config, _ = locateDefaultConfig()
+	}
+
+	if config != "" {
+		if abs, err := filepath.Abs(config); err == nil {
+			config = abs
+		}
+	}
+
+	e.Config = config
+
+	for name, n := range m.Rows {
+		e.Rows[name] = n
+	}
+
+	for name, o := range m.Outputs {
+		name = filepath.Join(dir, name)
+
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
+		}
+
+		e.Outputs[name] = o
+	}
+
+	return e
+}
+
+// readAudit reads the records of an audit log.
+func readAudit(in io.Reader) (records []auditRecord, err error) {
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 1<<16), 1<<26)
+
+	line := 0
+
+	for s.Scan() {
+		line++
+
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		var r auditRecord
+
+		err := json.Unmarshal(s.Bytes(), &r)
+		if err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", line, err)
+		}
+
+		records = append(records, r)
+	}
+
+	return records, s.Err()
+}
+
+// AppendAudit appends an entry to an audit log, chaining it to the last entry. The audit log is created if it does not exist, readable by the owner only.
+// The audit log is locked while the last entry is read and the entry is appended, so that concurrent runs chain their entries in turn.
+func AppendAudit(name string, e AuditEntry) (err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = lockFile(f)
+	if err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	records, err := readAudit(f)
+	if err != nil {
+		return err
+	}
+
+	e.Seq = int64(len(records)) + 1
+	e.Prev = ""
+
+	if len(records) > 0 {
+		var last AuditEntry
+
+		err := json.Unmarshal(records[len(records)-1].Entry, &last)
+		if err != nil {
+			return err
+		}
+
+		e.Seq = last.Seq + 1
+		e.Prev = records[len(records)-1].Hash
+	}
+
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(auditRecord{Hash: auditHash(entry), Entry: entry})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	err = unlockFile(f)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// VerifyAudit checks the hash chain of an audit log and returns the number of entries verified and the hash of the last entry.
+// An edited entry does not match its hash, and a deleted or reordered entry breaks the chain of previous entry hashes and sequence numbers.
+// Deletion of the last entries cannot be detected from the audit log alone: the chain of the remaining entries is intact. The last entry hash should be compared to an external anchor, e.g. a hash recorded elsewhere after a previous verification.
+func VerifyAudit(in io.Reader) (n int64, last string, err error) {
+	records, err := readAudit(in)
+	if err != nil {
+		return 0, "", err
+	}
+
+	prev := ""
+	var seq int64
+
+	for _, r := range records {
+		n++
+
+		if auditHash(r.Entry) != r.Hash {
+			return n - 1, prev, fmt.Errorf("audit log entry %d: entry does not match its hash", n)
+		}
+
+		var e AuditEntry
+
+		err := json.Unmarshal(r.Entry, &e)
+		if err != nil {
+			return n - 1, prev, fmt.Errorf("audit log entry %d: %w", n, err)
+		}
+
+		if e.Prev != prev {
+			return n - 1, prev, fmt.Errorf("audit log entry %d: previous entry hash does not match, entries were deleted, edited, or reordered", n)
+		}
+
+		if n > 1 && e.Seq != seq+1 {
+			return n - 1, prev, fmt.Errorf("audit log entry %d: sequence number %d follows %d", n, e.Seq, seq)
+		}
+
+		prev = r.Hash
+		seq = e.Seq
+	}
+
+	return n, prev, nil
+}
+
+// audit runs an audit subcommand.
+func audit(f auditFlags, out io.Writer) (err error) {
+	switch f.action {
+	case "verify":
+		name := auditPath(*f.audit, *f.outputDir)
+
+		in, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		n, last, err := VerifyAudit(in)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out, "audit log", name, "verified:", n, "entries, last entry hash", last)
+
+		return err
+	default:
+		return errors.New("unknown audit action '" + f.action + "', expected 'verify'")
+	}
+}
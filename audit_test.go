@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperAudit appends entries for runs to an audit log and returns the lines of the log.
+func helperAudit(t *testing.T, name string, runs []string) []string {
+	for _, run := range runs {
+		err := AppendAudit(name, AuditEntry{Run: run, Patients: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.SplitAfter(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestAudit(t *testing.T) {
+	name := "test-audit.jsonl"
+
+	defer os.Remove(name)
+
+	lines := helperAudit(t, name, []string{"run1", "run2", "run3"})
+
+	t.Run("Verify audit log", func(t *testing.T) {
+		n, last, err := VerifyAudit(strings.NewReader(strings.Join(lines, "")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(3), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		var r auditRecord
+
+		err = json.Unmarshal([]byte(lines[2]), &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff = cmp.Diff(r.Hash, last)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Create audit log readable by the owner only", func(t *testing.T) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(os.FileMode(0o600), info.Mode().Perm())
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	tampered := map[string][]string{
+		"Detect edited entries":    {lines[0], strings.Replace(lines[1], `"patients":1`, `"patients":2`, 1), lines[2]},
+		"Detect deleted entries":   {lines[0], lines[2]},
+		"Detect reordered entries": {lines[1], lines[0], lines[2]},
+	}
+
+	for name, tc := range tampered {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			_, _, err := VerifyAudit(strings.NewReader(strings.Join(tc, "")))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	t.Run("Detect entries with recomputed hashes", func(t *testing.T) {
+		var r auditRecord
+
+		err := json.Unmarshal([]byte(lines[1]), &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Entry = bytes.Replace(r.Entry, []byte(`"patients":1`), []byte(`"patients":2`), 1)
+		r.Hash = auditHash(r.Entry)
+
+		edited, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = VerifyAudit(strings.NewReader(lines[0] + string(edited) + "\n" + lines[2]))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestAuditConcurrent(t *testing.T) {
+	name := "test-audit-concurrent.jsonl"
+
+	defer os.Remove(name)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := AppendAudit(name, AuditEntry{Run: "run", Patients: 1})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, _, err := VerifyAudit(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := cmp.Diff(int64(10), n)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestAuditPath(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"Default audit log in output directory": {input: AuditFile, want: filepath.Join("out", AuditFile)},
+		"Audit log path as given":               {input: "audit.jsonl", want: "audit.jsonl"},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, auditPath(tc.input, "out"))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestPatients(t *testing.T) {
+	p := NewPatients([]string{"MRN", "Value"})
+
+	p.Add([]string{"1000000001", "a"})
+	p.Add([]string{"1000000002", "b"})
+	p.Add([]string{"1000000001", "c"})
+
+	diff := cmp.Diff(int64(2), p.N())
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestFullAudit(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	name := "test-full-audit.jsonl"
+
+	defer os.Remove(name)
+
+	f := helperFlags()
+	f.audit = &name
+
+	innerTest(f, TestFile)
+	innerTest(f, TestFile)
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Append an entry per run", func(t *testing.T) {
+		n, _, err := VerifyAudit(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(2), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Record patients and output checksums", func(t *testing.T) {
+		var r auditRecord
+
+		err := json.Unmarshal(bytes.SplitN(b, []byte("\n"), 2)[0], &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var e AuditEntry
+
+		err = json.Unmarshal(r.Entry, &e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make(map[string](struct{}))
+		for _, v := range helperColumn(t, TestFile, 0) {
+			ids[v] = struct{}{}
+		}
+
+		diff := cmp.Diff(int64(len(ids)), e.Patients)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		results, err := filepath.Abs("results.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Outputs[results].SHA256 == "" || !e.Complete || e.User == "" {
+			t.Fatal("incomplete audit log entry")
+		}
+	})
+}
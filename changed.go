@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// keyIndices gets the column indices of natural key column names.
+func keyIndices(header []string, key []string) (idx []int, err error) {
+	colNames := headerParse(header)
+
+	for _, k := range key {
+		i, ok := colNames[k]
+		if !ok {
+			return nil, errors.New(strings.Join([]string{"key column", k, "does not exist in input data"}, " "))
+		}
+
+		idx = append(idx, i)
+	}
+
+	return idx, nil
+}
+
+// keyValues gets the natural key values of a record.
+func keyValues(l []string, idx []int) (k []string) {
+	k = make([]string, len(idx))
+
+	for i, j := range idx {
+		if j < len(l) {
+			k[i] = l[j]
+		}
+	}
+
+	return k
+}
+
+// ChangedHeader creates the header of changed record output: natural key columns followed by the changed column name and its old and new values.
+func ChangedHeader(key []string) (h []string) {
+	h = append(h, key...)
+	h = append(h, "Column", "OldValue", "NewValue")
+
+	return h
+}
+
+// changedColumns compares an old and a new record and returns a row for each changed column.
+// Values are compared after normalization. Only columns of the existing output file are compared, so that columns dropped from the file, e.g. by a projection, are not changed.
+func changedColumns(k []string, header []string, cols []string, x *identifier, old []string, new []string) (rows [][]string) {
+	oldNorm := x.normalize(old)
+	newNorm := x.normalize(new)
+
+	oldCols := headerParse(cols)
+
+	for i, col := range header {
+		if _, ok := oldCols[col]; !ok {
+			continue
+		}
+
+		var o, n, oNorm, nNorm string
+
+		if i < len(old) {
+			o = old[i]
+			oNorm = oldNorm[i]
+		}
+
+		if i < len(new) {
+			n = new[i]
+			nNorm = newNorm[i]
+		}
+
+		if oNorm == nNorm {
+			continue
+		}
+
+		row := make([]string, 0, len(k)+3)
+		row = append(row, k...)
+		row = append(row, col, o, n)
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// Changed classifies records as new, changed (amended), or unchanged compared to the records of an existing output file using its natural key, e.g. AccessionNumber and TestTypeLocalID.
+// Input records are passed through to an output channel. Once input is exhausted, the old and new values of each changed column of each changed record are sent to a second output channel, in input order. Existing records of changed records are read again from the existing output file, so that only changed records are held in memory.
+// The natural key should uniquely identify a record. If it does not, the last existing record with a given key is used for comparison.
+// Records are compared using the identifying columns and normalization of the existing output file records.
+// Changed records are recorded in the run ledger of a state database, if provided.
+func Changed(old *Old, state *State, in chan []string, header []string) (out chan []string, changed chan []string, done chan struct{}) {
+	var buf int64 = 1e5
+	out = make(chan []string, buf)
+	changed = make(chan []string, buf)
+	done = make(chan struct{})
+
+	colNames := headerParse(header)
+
+	idCol, err := RecordID(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	go func() {
+		old.wait()
+
+		// current records with changed content, in input order
+		current := make(map[[blake2b.Size256]byte]([]string))
+		var order [][blake2b.Size256]byte
+
+		var nNew, nChanged, nUnchanged int64
+
+		for l := range in {
+			i := l
+			out <- i
+
+			kh, err := old.keyHash(l)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			h, err := old.hash(l)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			prev, ok := old.keys[kh]
+
+			switch {
+			case !ok:
+				nNew++
+			case prev.hash == h:
+				nUnchanged++
+			default:
+				nChanged++
+
+				if _, ok := current[kh]; !ok {
+					order = append(order, kh)
+				}
+				current[kh] = i
+
+				state.Record("changed", column(l, colNames, idCol), column(l, colNames, "AccessionNumber"))
+			}
+		}
+
+		close(out)
+
+		log.Println("records by natural key:", nNew, "new,", nChanged, "changed,", nUnchanged, "unchanged")
+
+		// existing records of changed records, read again from the existing output file
+		prev := make(map[[blake2b.Size256]byte]([]string))
+
+		if len(order) > 0 {
+			_, err := old.each(func(seq uint64, row []string) error {
+				kh, err := old.keyHash(row)
+				if err != nil {
+					return err
+				}
+
+				if _, ok := current[kh]; ok && old.keys[kh].seq == seq {
+					prev[kh] = row
+				}
+
+				return nil
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		for _, kh := range order {
+			l := current[kh]
+
+			for _, row := range changedColumns(keyValues(l, old.key), header, old.cols, old.x, prev[kh], l) {
+				changed <- row
+			}
+		}
+
+		close(changed)
+		close(done)
+	}()
+
+	return out, changed, done
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChanged(t *testing.T) {
+	old := TestFileOld
+
+	in := helperTestReader(TestFile)
+
+	key := []string{"MRN"}
+
+	out, changed, done := Changed(LoadOld(context.Background(), old, helperCorrectHeader(), Identity{}, key, 0), nil, in, helperCorrectHeader())
+
+	var passed int64
+	for range out {
+		passed++
+	}
+
+	var rows [][]string
+	for l := range changed {
+		rows = append(rows, l)
+	}
+
+	<-done
+
+	t.Run("Pass through current records", func(t *testing.T) {
+		diff := cmp.Diff(int64(12), passed)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Detect changed columns", func(t *testing.T) {
+		for _, l := range rows {
+			if l[0] != "Unchanged1" {
+				continue
+			}
+
+			diff := cmp.Diff([]string{"Unchanged1", "TestTypeMnemonic", "WBC", "XXX"}, l)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+
+			return
+		}
+
+		t.Fatal("failed to detect changed record")
+	})
+}
+
+func TestChangedHeader(t *testing.T) {
+	got := ChangedHeader([]string{"AccessionNumber", "TestTypeLocalID"})
+	want := []string{"AccessionNumber", "TestTypeLocalID", "Column", "OldValue", "NewValue"}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestChangedProjected(t *testing.T) {
+	old := "test-changed-projected.csv"
+
+	helperOldFile(t, old, "AccessionNumber,MRN\nA1,1000000001\n")
+	defer os.Remove(old)
+
+	header := []string{"AccessionNumber", "MRN", "Value"}
+
+	in := make(chan []string, 1)
+	in <- []string{"A1", "1000000002", "x"}
+	close(in)
+
+	out, changed, done := Changed(LoadOld(context.Background(), old, header, Identity{Columns: []string{"AccessionNumber", "MRN"}}, []string{"AccessionNumber"}, 0), nil, in, header)
+
+	for range out {
+	}
+
+	var rows [][]string
+	for l := range changed {
+		rows = append(rows, l)
+	}
+
+	<-done
+
+	t.Run("Compare only columns of the old file", func(t *testing.T) {
+		diff := cmp.Diff([][]string{{"A1", "MRN", "1000000001", "1000000002"}}, rows)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
@@ -3,43 +3,210 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 )
 
 // flagVars contains variables set by command line flags.
 type flags struct {
-	config   *string
-	example  *bool
-	noFilter *bool
-	old      *string
-	sql      *bool
+	audit         *string
+	compress      *string
+	config        *string
+	dateShift     *string
+	deterministic *bool
+	encryptTo     *string
+	example       *bool
+	identity      *string
+	keepRuns      *int
+	noFilter      *bool
+	old           *string
+	outputDir     *string
+	outputFormat  *string
+	passphrase    *string
+	pseudonymKey  *string
+	runs          *bool
+	scrub         *bool
+	sortMemory    *int64
+	sql           *bool
+	state         *string
+	stdout        *string
 }
 
 // flags parses command line flags.
 func flagParse() (f flags) {
+	audit := flag.String("audit", AuditFile, "Path to the append-only audit log of runs; the default audit log is in --output-dir; set to an empty string to disable")
+	compress := flag.String("compress", "", "Compress CSV and JSON Lines output files, 'gzip' or 'zstd', adding the extension .gz or .zst (optional)")
 	config := flag.String("config", "", "Path to ih-abstract.yml SQL connection configuration file")
+	dateShift := flag.String("date-shift", "", "Path to a key file of per-patient date offsets; shift dates and replace DOB with age at result in pdl1.csv, msi.csv, and wbc.csv (optional)")
+	deterministic := flag.Bool("deterministic", false, "Write outputs in input order and sort identifier lists, so that identical runs produce identical outputs")
+	encryptTo := flag.String("encrypt-to", "", "Path to a file of age recipient public keys; encrypt output files (optional)")
 	example := flag.Bool("print-config", false, "Print an example configuration file and exit")
+	identity := flag.String("identity", "", "Path to an age identity file; decrypt encrypted --old and previous output files (optional)")
+	keepRuns := flag.Int("keep-runs", 0, "Number of successful run directories to keep with --runs; older run directories are pruned (default keep all)")
 	noFilter := flag.Bool("no-filter", false, "Save input data to .csv and exit without Immune Health filtering")
 	old := flag.String("old", "", "Path to existing results.csv output data from last run (optional)")
+	outputDir := flag.String("output-dir", ".", "Directory to write output files to, created readable by the owner only if it does not exist")
+	outputFormat := flag.String("output-format", CSV, "Format of result files, 'csv', 'jsonl', 'parquet', or 'sqlite'")
+	passphrase := flag.String("passphrase-file", "", "Path to a file containing a passphrase; encrypt output files and decrypt encrypted input files (optional)")
+	pseudonymKey := flag.String("pseudonym-key", "", "Path to a secret key file; replace identifier columns of pdl1.csv, msi.csv, and wbc.csv with keyed pseudonyms (optional)")
+	runs := flag.Bool("runs", false, "Write each run to a timestamped directory runs/<run> in --output-dir, pointed to by runs/latest on success; --old defaults to the results of the latest run")
+	scrub := flag.Bool("scrub", false, "Scrub names, MRNs, accession numbers, dates, and phone numbers from unique PD-L1/MSI strings (optional)")
+	sortMemory := flag.Int64("sort-memory", 0, "Diff against --old using an external sort to temporary files within this memory budget in MiB, instead of an in-memory hash map (optional)")
 	sql := flag.Bool("sql", false, "Read input from Microsoft SQL database instead of Stdin")
+	state := flag.String("state", "", "Path to state database of record hashes from previous runs (optional)")
+	stdout := flag.String("stdout", "", "Output category to stream to stdout instead of a file, e.g. results or pdl1; logs are written to stderr (optional)")
 
 	flag.Parse()
 
+	f.audit = audit
+	f.compress = compress
 	f.config = config
+	f.dateShift = dateShift
+	f.deterministic = deterministic
+	f.encryptTo = encryptTo
 	f.example = example
+	f.identity = identity
+	f.keepRuns = keepRuns
 	f.noFilter = noFilter
 	f.old = old
+	f.outputDir = outputDir
+	f.outputFormat = outputFormat
+	f.passphrase = passphrase
+	f.pseudonymKey = pseudonymKey
+	f.runs = runs
+	f.scrub = scrub
+	f.sortMemory = sortMemory
 	f.sql = sql
+	f.state = state
+	f.stdout = stdout
 
 	return
 }
 
+// historyFlags contains variables set by history subcommand command line flags.
+type historyFlags struct {
+	accession *string
+	mrn       *string
+	state     *string
+}
+
+// historyFlagParse parses history subcommand command line flags.
+func historyFlagParse(args []string) (f historyFlags) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+
+	f.accession = fs.String("accession", "", "Accession number to list run history for")
+	f.mrn = fs.String("mrn", "", "Patient identifier to list run history for")
+	f.state = fs.String("state", "", "Path to state database of record hashes from previous runs")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nList the runs in which results of a patient or accession number appeared, changed, or were removed.\n")
+		fmt.Fprintf(os.Stderr, "\nUSAGE:\n\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract history --state ih-abstract.db --mrn 1000000001\n")
+		fmt.Fprintf(os.Stderr, "\nDEFAULTS:\n\n")
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return f
+}
+
+// reviewFlags contains variables set by review subcommand command line flags.
+type reviewFlags struct {
+	action   string
+	all      *bool
+	category *string
+	reviewer *string
+	state    *string
+	files    []string
+}
+
+// reviewFlagParse parses review subcommand command line flags.
+// The first argument is the review action, 'list' or 'import', followed by flags and, for 'import', review decision files.
+func reviewFlagParse(args []string) (f reviewFlags) {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+
+	f.all = fs.Bool("all", false, "List reviewed strings in addition to strings pending review")
+	f.category = fs.String("category", "", "Category of unique strings to list, 'pdl1' or 'msi' (default all)")
+	f.reviewer = fs.String("reviewer", "", "Reviewer of imported decisions without a reviewer")
+	f.state = fs.String("state", "", "Path to state database of record hashes from previous runs")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nList unique PD-L1/MSI strings pending quality assurance review, or import review decisions.\n")
+		fmt.Fprintf(os.Stderr, "\nUSAGE:\n\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract review list --state ih-abstract.db [--category pdl1] > review.csv\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract review import --state ih-abstract.db --reviewer jdoe review.csv\n")
+		fmt.Fprintf(os.Stderr, "\nSet 'status' of each string to approved, rejected, or mapped. Mapped strings require a\n")
+		fmt.Fprintf(os.Stderr, "'canonical' value. Strings left pending are skipped.\n")
+		fmt.Fprintf(os.Stderr, "\nDEFAULTS:\n\n")
+		fs.PrintDefaults()
+	}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		f.action = args[0]
+		args = args[1:]
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	f.files = fs.Args()
+
+	return f
+}
+
+// auditFlags contains variables set by audit subcommand command line flags.
+type auditFlags struct {
+	action    string
+	audit     *string
+	outputDir *string
+}
+
+// auditFlagParse parses audit subcommand command line flags.
+// The first argument is the audit action, 'verify', followed by flags.
+func auditFlagParse(args []string) (f auditFlags) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+
+	f.audit = fs.String("audit", AuditFile, "Path to the audit log of runs; the default audit log is in --output-dir")
+	f.outputDir = fs.String("output-dir", ".", "Output directory of the default audit log")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nVerify the hash chain of the audit log of runs, detecting deleted or edited entries.\n")
+		fmt.Fprintf(os.Stderr, "Deleted last entries are detected only by comparing the last entry hash to a hash recorded elsewhere.\n")
+		fmt.Fprintf(os.Stderr, "\nUSAGE:\n\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract audit verify [--audit ih-abstract-audit.jsonl]\n")
+		fmt.Fprintf(os.Stderr, "\nDEFAULTS:\n\n")
+		fs.PrintDefaults()
+	}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		f.action = args[0]
+		args = args[1:]
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return f
+}
+
 // usage prints usage.
 func usage() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "\nSelect raw data for Immune Health report generation.\n")
 		fmt.Fprintf(os.Stderr, "\nUSAGE:\n\n")
 		fmt.Fprintf(os.Stderr, "  < results-raw.csv | ih-abstract\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract history --state ih-abstract.db [--mrn MRN | --accession ACCESSION]\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract review [list | import] --state ih-abstract.db\n")
+		fmt.Fprintf(os.Stderr, "  ih-abstract audit verify [--audit ih-abstract-audit.jsonl]\n")
 		fmt.Fprintf(os.Stderr, "\nDEFAULTS:\n\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, `
@@ -56,10 +223,68 @@ DETAILS:
   Quality assurance output consists of files containing
     1) unique, and
     2) new (never-before-seen)
-  Immune Health report results for manual review.
+  Immune Health report results for manual review. With a state database (--state), review
+  decisions (approved, rejected, or mapped to a canonical value) are recorded with reviewer
+  and time, new unique strings are those nobody has reviewed, and unique strings are written
+  with a 'canonical' column, the value of strings mapped by review. See 'ih-abstract review -h'.
+
+  With --scrub, names (from the PatientName column of the run), phone numbers, dates,
+  accession numbers, MRNs, and configuration file 'scrub' patterns are replaced in unique
+  strings with typed placeholders, e.g. [NAME] or [DATE], before comparison and writing.
+
+  Output row order may vary between identical runs because filtering is parallel. With
+  --deterministic, input rows are tagged with sequence numbers, every output is written
+  in input order, and identifier lists and the pseudonym crosswalk are sorted, so that
+  outputs of identical runs can be compared with diff or archived for validation.
 
-  Dependencies are vendored and consist of the Go standard library and
-  a Go Microsoft SQL driver.
+  Output files are written to --output-dir, readable by the owner only, via temporary files
+  that replace the previous outputs, with the run manifest, only once the whole run
+  succeeds; if replacing fails, the previous outputs are restored. Interrupting a run
+  (SIGINT/SIGTERM) cancels the SQL query, drains the pipeline, and discards the temporary
+  files; a failed or interrupted run leaves the previous outputs untouched for --old.
+
+  With --output-format jsonl, result files (e.g. results.jsonl) are written as JSON Lines:
+  one JSON object per row, with the header as keys and values as strings. JSON Lines results
+  cannot be used as --old, so --state is required unless results have a 'csv' sink.
+
+  With --stdout, one output category, e.g. results, results-increment, pdl1, or
+  pdl1-unique-strings, is streamed to stdout in its output format instead of being written
+  to a file, while logs are written to stderr, for use in shell pipelines, e.g.
+  'ih-abstract --output-format jsonl --state state.db --stdout pdl1 < results-raw.csv | jq .Value'. The run
+  fails if it does not write the category. Streamed output cannot be withdrawn if the run
+  fails later, and is not available to the next run as --old or previous unique strings.
+  With --output-format sqlite, the category is streamed as CSV.
+
+  With --output-format parquet, result files (e.g. results.parquet, wbc.parquet) are written
+  as Parquet with a typed schema: DOB and columns ending in 'Date' are timestamps (null if
+  unparseable), AgeAtResult is numeric, Value is kept as a string with an additional
+  ValueNumeric column of values that parse as numbers, and other columns are strings. Rows
+  are written in row groups of about 64 MiB. Unique strings and identifier lists remain
+  CSV/.txt. Parquet results cannot be used as --old, so --state is required unless results
+  have a 'csv' sink.
+
+  With --output-format sqlite, all outputs are written as tables of a single SQLite database,
+  ih-abstract.sqlite, for ad hoc queries. Tables are named after output files, e.g. results,
+  results_increment, pdl1_unique_strings, or new_ids_txt, with TEXT columns, and are indexed
+  on MRN, AccessionNumber, and ResultDate where present. The metadata table holds the run
+  manifest as key/value pairs, with the whole manifest as JSON under the key 'manifest'.
+  Unique strings and identifier lists are also written as CSV/.txt files; the pseudonym
+  crosswalk is never written to the database. As with Parquet, --state is required unless
+  results have a 'csv' sink. The database is built in a temporary file in the output directory, readable by the
+  owner only, which is removed if the run fails. The database cannot be encrypted, so the
+  sqlite output format and sqlite sinks are rejected with encrypted outputs.
+
+  With --runs, each run is written to its own directory, runs/<run> (a UTC timestamp), in
+  --output-dir. The file runs/latest names the latest successful run. Unless --old is given,
+  results are diffed against runs/<latest>/results.csv, and new unique strings are those absent
+  from the latest run, so results need not be moved aside before each run. With --keep-runs,
+  only that many successful run directories are kept; older and failed runs are pruned.
+
+  Dependencies are vendored and consist of the Go standard library, a Go Microsoft SQL
+  driver, the bbolt key/value store for the state database (--state), age for encrypted
+  outputs (--encrypt-to, --passphrase-file), parquet-go for Parquet outputs, a pure Go
+  SQLite driver for SQLite outputs, and klauspost/compress for zstd compression
+  (--compress zstd).
 
 OUTPUT:
 
@@ -67,7 +292,35 @@ OUTPUT:
 
     results.csv:                     all results
     results-increment.csv:           new results since last run
+    manifest.json:                   run manifest: version, start and end times, input checksum,
+                                     configuration fingerprint, row counts, and output checksums
+    ih-abstract-audit.jsonl:         append-only audit log of runs (--audit), readable by the
+                                     owner only
+    ih-abstract.sqlite:              all outputs as indexed tables, with the run manifest
+                                     (--output-format sqlite)
     new-ids.txt:                     patient identifiers with new results since last run
+    new-ids.csv:                     patient identifiers with new results since last run, with
+                                     report categories, number of new results, first and last
+                                     new result dates, and accession numbers
+    results-removed.csv:             results of last run (--old) absent from this run
+    removed-ids.txt:                 patient identifiers with removed results since last run
+    results-changed.csv:             changed column values of amended results since last run,
+                                     by natural key (configuration file 'key')
+
+  New results are identified by comparison to the state database (--state), if provided,
+  or to the results of the last run (--old). If both are provided, the results of the
+  last run are imported to the state database. The state database is updated with the
+  hashes of new results and a ledger of results that appeared, changed, or were removed
+  at the end of each successful run, once its outputs are committed. See 'ih-abstract
+  history -h'. Results are identified by the columns and value normalization (trim, lower,
+  upper, space, date) defined in configuration file 'identity', or by all columns if
+  undefined. For very large histories, --sort-memory diffs against the last run by sorting
+  record hashes to temporary files and merging them, to identify new and removed results
+  with the same output and bounded memory use. The results of the last run are streamed
+  from disk, not held in memory; with a natural key ('key'), key hashes of the last run are
+  held in memory to identify changed results. Temporary files are written to --output-dir,
+  readable by the owner only, and are encrypted with a key held only in memory if outputs
+  are encrypted.
 
  Output for Immune Health report quality assurance:
 
@@ -75,12 +328,67 @@ OUTPUT:
     msi.csv:                         potential MSI reports
     cpd.csv:                         potential CPD reports
     wbc.csv:                         white blood cell counts
+    pseudonyms.csv:                  crosswalk from pseudonyms to identifiers (--pseudonym-key),
+                                     readable by the owner only
 
     pdl1-unique-strings.txt:         unique PD-L1 strings
     pdl1-unique-strings-new.txt:     unique PD-L1 strings, new vs. last run
     msi-unique-strings.txt:          unique MSI strings
     msi-unique-strings-new.txt:      unique MSI strings, new vs. last run
 
+  With --pseudonym-key, identifier columns (configuration file 'pseudonymize', default MRN,
+  PatientName, and MedViewPatientID) of pdl1.csv, msi.csv, and wbc.csv are replaced with
+  keyed HMAC-SHA256 pseudonyms that are stable across runs for the same key. Diffing and
+  new-ids use the real identifiers.
+
+  With --date-shift, dates (DrawnDate, ResultDate) of pdl1.csv, msi.csv, and wbc.csv are
+  shifted by a random offset of up to 365 days that is consistent for each patient, so that
+  intervals between the results of a patient are intact, and DOB is replaced by AgeAtResult.
+  Offsets are kept in the key file, which must be readable by the owner only, so that repeated
+  runs shift the dates of a patient identically. The key file is saved only when the run is
+  committed. Dates that cannot be parsed are replaced with [DATE], with a warning.
+
+  The sink of each output category can be chosen in configuration file 'sinks', by output
+  name: a file of an output format ('csv', 'jsonl', or 'parquet'), a table of the SQLite
+  database ih-abstract.sqlite ('sqlite'), or stdout ('stdout', like --stdout, for at most one
+  category). Other categories use --output-format. For example, 'sinks: {wbc: jsonl}' writes
+  wbc.jsonl. Output names are results, results-increment, results-changed, results-removed,
+  pdl1, msi, cpd, wbc, and the unique strings, e.g. pdl1-unique-strings-new, and new-ids,
+  new-ids-summary (new-ids.csv), removed-ids, and pseudonyms, which are CSV files unless they
+  have a sink, named after the output name, e.g. new-ids.jsonl. Unknown output names are
+  rejected. Sinks of results and unique strings other than 'csv' are rejected, since the next
+  run diffs against them; results may use other sinks with --state. The pseudonym crosswalk
+  must be written to a file.
+
+  Columns of each output file can be limited in configuration file 'columns', by output
+  name, to an allowlist ('include', written in the given order) or a denylist ('exclude'),
+  so that each file holds only the fields its analysis needs. Projections apply to output
+  names written in --output-format, not to new-ids, new-ids-summary, removed-ids, or
+  pseudonyms, and unknown output names are rejected. Outputs diffed by the next run are checked: results must keep the identifying
+  columns ('identity') and natural key ('key') columns, and cannot be projected if all
+  columns identify a result; unique strings must keep 'unique-result' as the first column.
+
+  With --compress gzip or --compress zstd, CSV and JSON Lines output files are compressed as
+  they are written and named with the extension .gz or .zst, e.g. results.csv.gz, before any
+  encryption suffix. Compressed --old and previous output files are decompressed
+  transparently, whatever the compression of this run. Parquet files are compressed
+  internally; the SQLite database and output streamed to stdout are not compressed.
+
+  With --encrypt-to or --passphrase-file, output files are encrypted as they are written
+  using age (https://age-encryption.org) and named with the suffix '.age'. Encrypted --old
+  and previous output files are decrypted transparently using --identity or --passphrase-file.
+  Encrypted outputs can be decrypted with the age command line tool.
+
+  Each run, complete or not, appends an entry to the audit log (--audit): OS user, host,
+  configuration file path and fingerprint, input and query checksums, parameters, row and
+  patient counts, and absolute output paths and checksums. Each entry contains the hash of
+  the previous entry, so that deleted or edited entries are detected by
+  'ih-abstract audit verify'. Deleting the last entries leaves an intact chain, so verify
+  prints the hash of the last entry: record it elsewhere, e.g. in a ticket or a separate
+  system, and compare it at the next verification. The audit log is locked while an entry
+  is appended. Runs that fail to start or to commit outputs are recorded as incomplete, with
+  the error.
+
 CONFIGURATION FILE:
 
   See 'ih-abstract --print-config'. Paths searched by default:
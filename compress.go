@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of output files.
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Compressions are the supported compression algorithms of output files.
+var Compressions = []string{Gzip, Zstd}
+
+// CompressedExtensions are the file name extensions of compressed output files by compression algorithm.
+var CompressedExtensions = map[string]string{
+	Gzip: ".gz",
+	Zstd: ".zst",
+}
+
+// Magic numbers of compressed files.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// checkCompression checks that a compression algorithm is supported. No compression is an empty string.
+func checkCompression(c string) (err error) {
+	if c == "" {
+		return nil
+	}
+
+	for _, a := range Compressions {
+		if a == c {
+			return nil
+		}
+	}
+
+	return errors.New(strings.Join([]string{"unknown compression", c, "expected one of", strings.Join(Compressions, ", ")}, " "))
+}
+
+// compressionKey is the context key of the compression algorithm of output files.
+type compressionKey struct{}
+
+// withCompression returns a context carrying the compression algorithm of output files.
+func withCompression(ctx context.Context, c string) context.Context {
+	return context.WithValue(ctx, compressionKey{}, c)
+}
+
+// compressionFrom gets the compression algorithm of output files from a context, or an empty string if outputs are not compressed.
+func compressionFrom(ctx context.Context) string {
+	c, _ := ctx.Value(compressionKey{}).(string)
+
+	return c
+}
+
+// compressible reports whether files of an output format are compressed. Parquet files are compressed internally.
+func compressible(format string) bool {
+	return format == CSV || format == JSONL
+}
+
+// compress creates a writer that compresses to an underlying writer using a compression algorithm, if any. Close must be called to finish compression.
+func compress(w io.Writer, c string) (io.WriteCloser, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// zstdReadCloser closes a zstd decoder, which returns nothing on Close.
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+// Close releases the resources of the decoder.
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+
+	return nil
+}
+
+// closers closes several closers, returning the first error.
+type closers []io.Closer
+
+// Close closes all closers.
+func (c closers) Close() (err error) {
+	for _, cl := range c {
+		e := cl.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// decompress creates a reader that decompresses gzip or zstd compressed input, identified by magic number, or reads uncompressed input unchanged.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	b := bufio.NewReader(r)
+
+	magic, _ := b.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(b)
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, err := zstd.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return zstdReadCloser{d}, nil
+	default:
+		return io.NopCloser(b), nil
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompress(t *testing.T) {
+	input := []byte("MRN,Value\n1000000001,Negative\n")
+
+	tests := map[string]struct {
+		input string
+		magic []byte
+	}{
+		"Compress with gzip": {input: Gzip, magic: gzipMagic},
+		"Compress with zstd": {input: Zstd, magic: zstdMagic},
+		"Write uncompressed": {input: "", magic: input[:4]},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			w, err := compress(&buf, tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = w.Write(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.HasPrefix(buf.Bytes(), tc.magic) {
+				t.Fatalf("missing magic number %x", tc.magic)
+			}
+
+			r, err := decompress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff(input, got)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestCheckCompression(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		ok    bool
+	}{
+		"Accept no compression": {input: "", ok: true},
+		"Accept gzip":           {input: Gzip, ok: true},
+		"Accept zstd":           {input: Zstd, ok: true},
+		"Reject unknown":        {input: "bzip2", ok: false},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.ok, checkCompression(tc.input) == nil)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestPreviousOutputCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withOutputs(context.Background(), o)
+
+	for _, name := range []string{"results.csv.zst", "pdl1.csv", "pdl1.csv.gz"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		ctx   context.Context
+		input string
+		want  string
+	}{
+		"Find compressed previous output":                {ctx: ctx, input: "results.csv", want: "results.csv.zst"},
+		"Prefer uncompressed output without compression": {ctx: ctx, input: "pdl1.csv", want: "pdl1.csv"},
+		"Prefer compression of this run":                 {ctx: withCompression(ctx, Gzip), input: "pdl1.csv", want: "pdl1.csv.gz"},
+		"Return uncompressed name if missing":            {ctx: ctx, input: "wbc.csv", want: "wbc.csv"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(filepath.Join(dir, tc.want), previousOutput(tc.ctx, tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFullCompressed(t *testing.T) {
+	defer cleanupTestFull()
+
+	zstd := Zstd
+	gzip := Gzip
+
+	f := helperFlags()
+	f.compress = &zstd
+
+	cleanupTestFull()
+
+	innerTest(f, TestFileOld)
+
+	old := "results-old.csv.zst"
+
+	defer os.Remove(old)
+
+	err := os.Rename("results.csv.zst", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.compress = &gzip
+	f.old = &old
+
+	innerTest(f, TestFile)
+
+	t.Run("Compress outputs", func(t *testing.T) {
+		for _, name := range []string{"results.csv", "pdl1.csv", "pdl1-unique-strings.csv", "new-ids.txt"} {
+			if _, err := os.Stat(name); err == nil {
+				t.Fatalf("uncompressed output %s", name)
+			}
+
+			if _, err := os.Stat(name + ".gz"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("Diff against compressed outputs of the last run", func(t *testing.T) {
+		r, err := openInput(context.Background(), "results-removed.csv.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the old results are filtered, as in TestFullEncrypted
+		diff := cmp.Diff(5, bytes.Count(got, []byte("\n")))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Read compressed unique strings of the last run", func(t *testing.T) {
+		r, err := openInput(context.Background(), "pdl1-unique-strings-new.csv.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff("unique-result\n", string(got))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
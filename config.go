@@ -19,18 +19,57 @@ host: host
 port: 443
 database: database
 query: SELECT TOP 100 FROM table
+null: ""
+key:
+  - AccessionNumber
+  - TestTypeLocalID
+identity:
+  columns:
+    - MRN
+    - AccessionNumber
+    - TestTypeLocalID
+    - ResultDate
+    - Value
+  normalize:
+    MRN: [trim]
+    ResultDate: [date]
+    Value: [space]
+pseudonymize:
+  - MRN
+  - PatientName
+  - MedViewPatientID
+scrub:
+  detectors: [phone, date, accession, mrn]
+  patterns:
+    ssn: '\b\d{3}-\d{2}-\d{4}\b'
+columns:
+  wbc:
+    include: [MRN, DrawnDate, Value]
+  pdl1:
+    exclude: [PatientName, DOB]
+sinks:
+  wbc: jsonl
 ...`
 	fmt.Println(config)
 }
 
-// confVars is a struct of configuration variables required for the SQL database connection.
+// confVars is a struct of configuration variables for the SQL database connection and result processing.
 type confVars struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	Database string `yaml:"database"`
-	Query    string `yaml:"query"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Host     string   `yaml:"host"`
+	Port     string   `yaml:"port"`
+	Database string   `yaml:"database"`
+	Query    string   `yaml:"query"`
+	Null     string   `yaml:"null"`     // string representation of SQL NULL values
+	Key      []string `yaml:"key"`      // natural key columns used to identify amended records
+	Identity Identity `yaml:"identity"` // columns and normalization used to identify records for diffing
+
+	Pseudonymize []string `yaml:"pseudonymize"` // identifier columns replaced with pseudonyms in de-identification mode
+	Scrub        Scrub    `yaml:"scrub"`        // PHI scrubber of unique strings
+
+	Columns map[string]Projection `yaml:"columns"` // column allowlists or denylists by output name
+	Sinks   map[string]string     `yaml:"sinks"`   // sink kinds by output name, the output format by default
 }
 
 // locateDefaultConfig locates the configuration file in $XDG_CONFIG_HOME, $HOME, or the current directory.
@@ -75,6 +114,20 @@ func locateDefaultConfig() (config string, err error) {
 	return "", errors.New("Cannot locate SQL database configuration file at default locations $XDG_CONFIG_HOME/ih-abstract/ih-abstract.yml, $HOME/.ih-abstract.yml, and ./ih-abstract.yml")
 }
 
+// runConfig loads the configuration file for a run.
+// The configuration file is optional unless reading from an SQL database.
+func runConfig(config string) (vars confVars, err error) {
+	if config == "" {
+		config, err = locateDefaultConfig()
+		if err != nil {
+			return vars, nil
+		}
+	}
+
+	return loadConfig(config)
+}
+
+// loadConfig loads a configuration file.
 func loadConfig(config string) (vars confVars, err error) {
 	y, err := ioutil.ReadFile(config)
 	if err != nil {
@@ -51,3 +51,21 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestRunConfig(t *testing.T) {
+	os.Setenv("XDG_CONFIG_HOME", "ih-abstractTestdirectory")
+	os.Setenv("HOME", "ih-abstractTestdirectory")
+
+	vars, err := runConfig("")
+
+	t.Run("Configuration file is optional", func(t *testing.T) {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(0, len(vars.Key))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
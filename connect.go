@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"log"
@@ -38,15 +40,18 @@ func connect(config string) (db *sql.DB, err error) {
 	return db, err
 }
 
-// rawRecords contains a header, a channel of raw records, and a channel indicating when reading is done.
+// rawRecords contains a header, a channel of raw records, a count of records read, the distinct patients read, and a channel indicating when reading is done.
 type rawRecords struct {
-	header []string
-	out    chan []string
-	done   chan struct{}
+	header   []string
+	out      chan []string
+	counter  *int64
+	patients *Patients
+	done     chan struct{}
 }
 
 // DB reads records from an Sql database.
-func DB(config string, db *sql.DB) (r rawRecords) {
+// The query is cancelled on the server if the context is cancelled.
+func DB(ctx context.Context, config string, db *sql.DB) (r rawRecords) {
 	defer db.Close()
 
 	var err error
@@ -60,14 +65,20 @@ func DB(config string, db *sql.DB) (r rawRecords) {
 	}
 
 	vars, err := loadConfig(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// the query, not its result, is fingerprinted
+	manifestFrom(ctx).SetInput("sql", "", fmt.Sprintf("%x", sha256.Sum256([]byte(vars.Query))))
 
 	// sql query
-	rows, err := db.Query(vars.Query)
+	rows, err := db.QueryContext(ctx, vars.Query)
 	if err != nil {
 		log.Fatalln("failed to run query", err)
 	}
 
-	r = readSQLRows(rows)
+	r = readSQLRows(ctx, rows, vars.Null)
 
 	return r
 }
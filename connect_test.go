@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql/driver"
 	"fmt"
 	"log"
@@ -27,7 +28,7 @@ func TestDBLive(t *testing.T) {
 
 	defer db.Close()
 
-	r := DB(config, db)
+	r := DB(context.Background(), config, db)
 
 	var counter int64
 	for range r.out {
@@ -70,7 +71,7 @@ func TestDBMock(t *testing.T) {
 	query := "SELECT"
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
-	r := DB("ih-abstract.yml", db)
+	r := DB(context.Background(), "ih-abstract.yml", db)
 
 	var counter int64
 
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// Encrypted is the file name suffix of encrypted outputs.
+const Encrypted = ".age"
+
+// ageHeader is the first line of age encrypted files.
+var ageHeader = []byte("age-encryption.org/v1")
+
+// scryptWorkFactor is the base-2 logarithm of the scrypt work factor of passphrase encryption.
+var scryptWorkFactor = 18
+
+// Crypt encrypts output files and decrypts input files at rest using age (https://age-encryption.org), either with a passphrase or with recipient public keys and identities.
+// Encryption and decryption are streamed. Key setup of each file is serialized because passphrase key derivation uses a large amount of memory.
+type Crypt struct {
+	recipients []age.Recipient
+	identities []age.Identity
+	sync.Mutex
+}
+
+// LoadCrypt loads age recipients to encrypt outputs, identities to decrypt inputs, and a passphrase to do both. Files that are not given are ignored.
+// A passphrase cannot be combined with recipients.
+func LoadCrypt(recipientsFile string, identityFile string, passphraseFile string) (c *Crypt, err error) {
+	c = &Crypt{}
+
+	if recipientsFile != "" {
+		f, err := os.Open(recipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		c.recipients, err = age.ParseRecipients(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if identityFile != "" {
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		c.identities, err = age.ParseIdentities(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if passphraseFile != "" {
+		if len(c.recipients) > 0 {
+			return nil, errors.New("a passphrase cannot be combined with encryption recipients")
+		}
+
+		b, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase := strings.TrimRight(string(b), "\r\n")
+		if passphrase == "" {
+			return nil, errors.New("passphrase file is empty")
+		}
+
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		r.SetWorkFactor(scryptWorkFactor)
+
+		i, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		c.recipients = append(c.recipients, r)
+		c.identities = append(c.identities, i)
+	}
+
+	return c, nil
+}
+
+// EphemeralCrypt creates encryption and decryption with a new key that is held only in memory, e.g. to encrypt temporary files that are read back by the same run.
+func EphemeralCrypt() (c *Crypt, err error) {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+
+	c = &Crypt{
+		recipients: []age.Recipient{i.Recipient()},
+		identities: []age.Identity{i},
+	}
+
+	return c, nil
+}
+
+// cryptKey is the context key of output encryption and input decryption.
+type cryptKey struct{}
+
+// withCrypt returns a context carrying output encryption and input decryption.
+func withCrypt(ctx context.Context, c *Crypt) context.Context {
+	return context.WithValue(ctx, cryptKey{}, c)
+}
+
+// cryptFrom gets the output encryption and input decryption of a context, or nil if there is none.
+func cryptFrom(ctx context.Context) *Crypt {
+	c, _ := ctx.Value(cryptKey{}).(*Crypt)
+
+	return c
+}
+
+// encrypting reports whether outputs are encrypted.
+func (c *Crypt) encrypting() bool {
+	return c != nil && len(c.recipients) > 0
+}
+
+// Name gets the file name of an output, with the Encrypted suffix if outputs are encrypted.
+func (c *Crypt) Name(name string) string {
+	if !c.encrypting() {
+		return name
+	}
+
+	return name + Encrypted
+}
+
+// nopWriteCloser is an io.WriteCloser with a Close method that does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Encrypt creates a writer that encrypts to an underlying writer if outputs are encrypted. Close must be called to finish encryption.
+func (c *Crypt) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if !c.encrypting() {
+		return nopWriteCloser{w}, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return age.Encrypt(w, c.recipients...)
+}
+
+// Decrypt creates a reader that decrypts an underlying reader if outputs are encrypted, e.g. to read back temporary files written with Encrypt.
+func (c *Crypt) Decrypt(r io.Reader) (io.Reader, error) {
+	if !c.encrypting() {
+		return r, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return age.Decrypt(r, c.identities...)
+}
+
+// readCloser combines a reader and the closer of an underlying file.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openInput opens an input file, e.g. the output of a previous run. Encrypted files are decrypted and compressed files are decompressed transparently.
+func openInput(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bufio.NewReader(f)
+
+	magic, err := b.Peek(len(ageHeader))
+	if err != nil || !bytes.Equal(magic, ageHeader) {
+		return decompressInput(b, f)
+	}
+
+	c := cryptFrom(ctx)
+	if c == nil || len(c.identities) == 0 {
+		f.Close()
+		return nil, errors.New(strings.Join([]string{"file", name, "is encrypted, an identity (--identity) or passphrase (--passphrase-file) is required"}, " "))
+	}
+
+	c.Lock()
+	r, err := age.Decrypt(b, c.identities...)
+	c.Unlock()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return decompressInput(r, f)
+}
+
+// decompressInput decompresses an input file that is compressed, or reads it unchanged.
+func decompressInput(r io.Reader, f *os.File) (io.ReadCloser, error) {
+	d, err := decompress(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return readCloser{d, closers{d, f}}, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperCryptWrite writes rows to an output file using output encryption and returns the file name.
+func helperCryptWrite(ctx context.Context, name string, rows [][]string) string {
+	w := File(ctx, name, helperCorrectHeader()[:2])
+
+	for _, l := range rows {
+		err := w.Write(l)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	return w.Name()
+}
+
+// helperCryptRead reads an input file using input decryption.
+func helperCryptRead(t *testing.T, ctx context.Context, name string) []byte {
+	f, err := openInput(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// helperScryptWorkFactor lowers the scrypt work factor of passphrase encryption for testing and returns a function restoring it.
+func helperScryptWorkFactor() func() {
+	w := scryptWorkFactor
+	scryptWorkFactor = 10
+
+	return func() {
+		scryptWorkFactor = w
+	}
+}
+
+func TestCryptPassphrase(t *testing.T) {
+	defer helperScryptWorkFactor()()
+
+	passphrase := "test-passphrase"
+
+	defer os.Remove(passphrase)
+
+	err := ioutil.WriteFile(passphrase, []byte("correct horse battery staple\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCrypt("", "", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withCrypt(context.Background(), c)
+
+	name := helperCryptWrite(ctx, "test-crypt.csv", [][]string{{"1000000001", "UID"}})
+	defer os.Remove(name)
+
+	t.Run("Name encrypted outputs", func(t *testing.T) {
+		diff := cmp.Diff("test-crypt.csv"+Encrypted, name)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Encrypt outputs", func(t *testing.T) {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.HasPrefix(b, ageHeader) || bytes.Contains(b, []byte("1000000001")) {
+			t.Fatal("output is not encrypted")
+		}
+	})
+
+	t.Run("Decrypt encrypted inputs", func(t *testing.T) {
+		diff := cmp.Diff("MRN,MRNFacility\n1000000001,UID\n", string(helperCryptRead(t, ctx, name)))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Require a key to decrypt encrypted inputs", func(t *testing.T) {
+		_, err := openInput(context.Background(), name)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("Read plaintext inputs", func(t *testing.T) {
+		b := helperCryptRead(t, ctx, TestFileOld)
+
+		if !bytes.HasPrefix(b, []byte("MRN,")) {
+			t.Fatal("plaintext input not read")
+		}
+	})
+
+	t.Run("Reject passphrase with recipients", func(t *testing.T) {
+		_, err := LoadCrypt(passphrase, "", passphrase)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestCryptRecipients(t *testing.T) {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, identity := "test-recipients.txt", "test-identity.txt"
+
+	defer os.Remove(recipients)
+	defer os.Remove(identity)
+
+	err = ioutil.WriteFile(recipients, []byte(i.Recipient().String()+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(identity, []byte(i.String()+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := LoadCrypt(recipients, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := LoadCrypt("", identity, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := helperCryptWrite(withCrypt(context.Background(), enc), "test-crypt.csv", [][]string{{"1000000001", "UID"}})
+	defer os.Remove(name)
+
+	t.Run("Decrypt outputs encrypted to a recipient", func(t *testing.T) {
+		diff := cmp.Diff("MRN,MRNFacility\n1000000001,UID\n", string(helperCryptRead(t, withCrypt(context.Background(), dec), name)))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+// helperFullOld runs ih-abstract on old data, then on current data with the results of the old run as --old, and returns the removed results.
+func helperFullOld(t *testing.T, f flags, ctx context.Context) []byte {
+	cleanupTestFull()
+
+	innerTest(f, TestFileOld)
+
+	results := cryptFrom(ctx).Name("results.csv")
+	old := cryptFrom(ctx).Name("results-old.csv")
+
+	defer os.Remove(old)
+
+	err := os.Rename(results, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.old = &old
+
+	innerTest(f, TestFile)
+
+	return helperCryptRead(t, ctx, cryptFrom(ctx).Name("results-removed.csv"))
+}
+
+func TestFullEncrypted(t *testing.T) {
+	defer helperScryptWorkFactor()()
+
+	defer cleanupTestFull()
+
+	passphrase := "test-passphrase"
+
+	defer os.Remove(passphrase)
+
+	err := ioutil.WriteFile(passphrase, []byte("correct horse battery staple\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCrypt("", "", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := helperFlags()
+	f.passphrase = &passphrase
+
+	got := helperFullOld(t, f, withCrypt(context.Background(), c))
+
+	t.Run("Encrypt all outputs", func(t *testing.T) {
+		for _, name := range []string{"results.csv", "pdl1.csv", "pdl1-unique-strings.csv", "new-ids.txt"} {
+			if _, err := os.Stat(name); err == nil {
+				t.Fatalf("plaintext output %s", name)
+			}
+
+			if _, err := os.Stat(name + Encrypted); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("Diff against encrypted outputs of the last run", func(t *testing.T) {
+		// the old results are filtered, so fewer records are removed than in TestFullFilter
+		diff := cmp.Diff(5, bytes.Count(got, []byte("\n")))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
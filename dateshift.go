@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShiftedDateColumns are the date columns of shared outputs shifted by a per-patient offset in date shifting mode.
+var ShiftedDateColumns = []string{"DrawnDate", "ResultDate"}
+
+// DOBColumn is the date of birth column, replaced by AgeColumn in date shifting mode.
+const DOBColumn = "DOB"
+
+// AgeColumn is the column of age in years at result, derived from DOBColumn in date shifting mode.
+const AgeColumn = "AgeAtResult"
+
+// OffsetHeader is the header of the date shift key file.
+var OffsetHeader = []string{"identifier", "offset-days"}
+
+// maxShift is the maximum absolute date shift in days.
+const maxShift = 365
+
+// shiftLayouts are the date layouts of shifted dates. Shifted dates keep their layout.
+var shiftLayouts = append([]string{TimeFormat}, dateLayouts...)
+
+// DateShifter shifts the dates of records by a random offset that is consistent for each patient, and replaces date of birth with age at result.
+// Offsets are kept in a key file readable by the owner only, so that repeated runs shift the dates of a patient identically.
+// Dates that cannot be parsed are counted, so that a warning is logged.
+type DateShifter struct {
+	name     string
+	offsets  map[string]int
+	added    int
+	unparsed int64
+	idIdx    int
+	dobIdx   int
+	dateIdx  []int
+	sync.Mutex
+}
+
+// LoadDateShifter loads the patient date offsets of a key file, if the file exists, for input data with a given header.
+func LoadDateShifter(name string, header []string) (d *DateShifter, err error) {
+	idCol, err := RecordID(header)
+	if err != nil {
+		return nil, err
+	}
+
+	colNames := headerParse(header)
+
+	d = &DateShifter{
+		name:    name,
+		offsets: make(map[string]int),
+		idIdx:   colNames[idCol],
+		dobIdx:  -1,
+	}
+
+	if i, ok := colNames[DOBColumn]; ok {
+		d.dobIdx = i
+	}
+
+	for _, col := range ShiftedDateColumns {
+		if i, ok := colNames[col]; ok {
+			d.dateIdx = append(d.dateIdx, i)
+		}
+	}
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		log.Println("date shift key file", name, "does not exist, creating")
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("date shift key file %s must be readable by the owner only", name)
+	}
+
+	r := csv.NewReader(f)
+
+	_, err = r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		l, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		days, err := strconv.Atoi(l[1])
+		if err != nil {
+			return nil, err
+		}
+
+		d.offsets[l[0]] = days
+	}
+
+	log.Println("loaded", len(d.offsets), "patient date offsets")
+
+	return d, nil
+}
+
+// randomOffset creates a random, non-zero date offset in days.
+func randomOffset() (days int, err error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(2*maxShift))
+	if err != nil {
+		return 0, err
+	}
+
+	days = int(n.Int64()) - maxShift
+	if days >= 0 {
+		days++
+	}
+
+	return days, nil
+}
+
+// offset gets the date offset of a patient, creating a random offset for a new patient.
+func (d *DateShifter) offset(id string) (days int) {
+	id = strings.TrimSpace(id)
+
+	d.Lock()
+	defer d.Unlock()
+
+	days, ok := d.offsets[id]
+	if ok {
+		return days
+	}
+
+	days, err := randomOffset()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	d.offsets[id] = days
+	d.added++
+
+	return days
+}
+
+// parseDate parses a date using the layouts of shifted dates.
+func parseDate(s string) (t time.Time, layout string, ok bool) {
+	for _, layout := range shiftLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, layout, true
+		}
+	}
+
+	return t, "", false
+}
+
+// shiftDate shifts a date by a number of days, keeping its layout.
+// Dates that cannot be parsed are replaced with a [DATE] placeholder, so that unshifted dates are not shared, and are not ok.
+func shiftDate(s string, days int) (shifted string, ok bool) {
+	if s == "" {
+		return s, true
+	}
+
+	t, layout, ok := parseDate(s)
+	if !ok {
+		return placeholder("date"), false
+	}
+
+	return t.AddDate(0, 0, days).Format(layout), true
+}
+
+// ageAt calculates age in whole years at a date from a date of birth. Age is empty if either date cannot be parsed.
+func ageAt(dob string, at string) string {
+	b, _, ok := parseDate(dob)
+	if !ok {
+		return ""
+	}
+
+	t, _, ok := parseDate(at)
+	if !ok {
+		return ""
+	}
+
+	years := t.Year() - b.Year()
+	if t.Month() < b.Month() || (t.Month() == b.Month() && t.Day() < b.Day()) {
+		years--
+	}
+
+	return strconv.Itoa(years)
+}
+
+// Header creates the header of date shifted records, replacing date of birth with age at result.
+func (d *DateShifter) Header(h []string) []string {
+	row := make([]string, len(h))
+	copy(row, h)
+
+	if d.dobIdx >= 0 && d.dobIdx < len(row) {
+		row[d.dobIdx] = AgeColumn
+	}
+
+	return row
+}
+
+// Row creates a copy of a record with dates shifted by the offset of the patient and date of birth replaced with age at result.
+// Age is calculated at the result date, or the drawn date if there is no result date. Age is empty if the date of birth cannot be parsed.
+func (d *DateShifter) Row(l []string) (row []string) {
+	row = make([]string, len(l))
+	copy(row, l)
+
+	if d.idIdx >= len(l) {
+		return row
+	}
+
+	days := d.offset(l[d.idIdx])
+
+	if d.dobIdx >= 0 && d.dobIdx < len(l) {
+		// the last date column with a value, ResultDate before DrawnDate
+		at := ""
+		for _, i := range d.dateIdx {
+			if i < len(l) && l[i] != "" {
+				at = l[i]
+			}
+		}
+
+		row[d.dobIdx] = ageAt(l[d.dobIdx], at)
+
+		if _, _, ok := parseDate(l[d.dobIdx]); !ok && l[d.dobIdx] != "" {
+			atomic.AddInt64(&d.unparsed, 1)
+		}
+	}
+
+	for _, i := range d.dateIdx {
+		if i >= len(row) {
+			continue
+		}
+
+		var ok bool
+
+		row[i], ok = shiftDate(row[i], days)
+		if !ok {
+			atomic.AddInt64(&d.unparsed, 1)
+		}
+	}
+
+	return row
+}
+
+// Save saves the patient date offsets to the key file, readable by the owner only.
+// The key file is written to a temporary file and replaced with the outputs of the run when the run is committed, so that offsets of a failed or cancelled run are not kept.
+func (d *DateShifter) Save(ctx context.Context) (err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	f, err := tempFile(d.name)
+	if err != nil {
+		return err
+	}
+
+	err = d.write(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	log.Println("saving", len(d.offsets), "patient date offsets,", d.added, "new")
+
+	return outputsFrom(ctx).add(f.Name(), d.name)
+}
+
+// write writes the patient date offsets to a key file readable by the owner only.
+func (d *DateShifter) write(f *os.File) (err error) {
+	err = f.Chmod(0o600)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+
+	err = w.Write(OffsetHeader)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(d.offsets))
+	for id := range d.offsets {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		err := w.Write([]string{id, strconv.Itoa(d.offsets[id])})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// Outputs replaces the named output channels with channels of date shifted records.
+// Records are copied because they are shared with other outputs. A warning is logged once all named outputs are done if dates could not be parsed.
+func (d *DateShifter) Outputs(channels map[string](chan []string), names []string) (done chan struct{}) {
+	done = make(chan struct{})
+
+	var buf int64 = 1e5
+
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		in, ok := channels[name]
+		if !ok {
+			continue
+		}
+
+		out := make(chan []string, buf)
+		channels[name] = out
+
+		wg.Add(1)
+
+		go func() {
+			for l := range in {
+				out <- d.Row(l)
+			}
+
+			close(out)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+
+		if n := atomic.LoadInt64(&d.unparsed); n > 0 {
+			log.Println("warning:", n, "dates could not be parsed and were replaced with", placeholder("date"), "or an empty", AgeColumn)
+		}
+
+		close(done)
+	}()
+
+	return done
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShiftDate(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		days  int
+		want  string
+	}{
+		"Shift date and time": {input: "2020-11-15 05:28:00.000", days: 20, want: "2020-12-05 05:28:00.000"},
+		"Shift date":          {input: "2020-03-01", days: -1, want: "2020-02-29"},
+		"Shift other layout":  {input: "11/15/2020", days: 1, want: "11/16/2020"},
+		"Keep empty date":     {input: "", days: 1, want: ""},
+		"Flag invalid date":   {input: "1950006-16 00:00:00.000", days: 1, want: "[DATE]"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			got, _ := shiftDate(tc.input, tc.days)
+
+			diff := cmp.Diff(tc.want, got)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestAgeAt(t *testing.T) {
+	tests := map[string]struct {
+		dob  string
+		at   string
+		want string
+	}{
+		"Age after birthday":  {dob: "1950-06-16 00:00:00.000", at: "2020-06-16 05:28:00.000", want: "70"},
+		"Age before birthday": {dob: "1950-06-16 00:00:00.000", at: "2020-06-15 05:28:00.000", want: "69"},
+		"Invalid birth date":  {dob: "1950006-16 00:00:00.000", at: "2020-06-15 05:28:00.000", want: ""},
+		"No result date":      {dob: "1950-06-16", at: "", want: ""},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, ageAt(tc.dob, tc.at))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestRandomOffset(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		days, err := randomOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if days == 0 || days < -maxShift || days > maxShift {
+			t.Fatalf("offset %d out of range", days)
+		}
+	}
+}
+
+func TestDateShifter(t *testing.T) {
+	name := "test-date-shift.csv"
+
+	defer os.Remove(name)
+
+	header := helperCorrectHeader()
+	colNames := headerParse(header)
+
+	record := func(mrn string, drawn string, result string) []string {
+		l := make([]string, len(header))
+		l[colNames["MRN"]] = mrn
+		l[colNames["DOB"]] = "1950-06-16 00:00:00.000"
+		l[colNames["DrawnDate"]] = drawn
+		l[colNames["ResultDate"]] = result
+
+		return l
+	}
+
+	pdl1 := record("1000000001", "2020-06-01 08:00:00.000", "2020-06-15 12:00:00.000")
+	wbc := record("1000000001", "2020-07-01 08:00:00.000", "2020-07-01 09:00:00.000")
+
+	d, err := LoadDateShifter(name, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shiftedPdl1 := d.Row(pdl1)
+	shiftedWbc := d.Row(wbc)
+
+	err = d.Save(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interval := func(a []string, b []string) time.Duration {
+		x, _, _ := parseDate(a[colNames["ResultDate"]])
+		y, _, _ := parseDate(b[colNames["ResultDate"]])
+
+		return y.Sub(x)
+	}
+
+	t.Run("Shift dates", func(t *testing.T) {
+		if shiftedPdl1[colNames["ResultDate"]] == pdl1[colNames["ResultDate"]] {
+			t.Fatal("date not shifted")
+		}
+	})
+
+	t.Run("Keep intervals between results of a patient", func(t *testing.T) {
+		diff := cmp.Diff(interval(pdl1, wbc), interval(shiftedPdl1, shiftedWbc))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Replace date of birth with age at result", func(t *testing.T) {
+		diff := cmp.Diff("69", shiftedPdl1[colNames["DOB"]])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		diff = cmp.Diff(AgeColumn, d.Header(header)[colNames["DOB"]])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Save key file readable by the owner only", func(t *testing.T) {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(os.FileMode(0o600), fi.Mode().Perm())
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Shift dates identically in repeated runs", func(t *testing.T) {
+		d, err := LoadDateShifter(name, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(shiftedPdl1, d.Row(pdl1))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Flag dates that cannot be parsed", func(t *testing.T) {
+		d, err := LoadDateShifter(name, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		row := d.Row(record("1000000001", "2020-06-01 08:00:00.000", "06-15-2020"))
+
+		diff := cmp.Diff(placeholder("date"), row[colNames["ResultDate"]])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		diff = cmp.Diff(int64(1), d.unparsed)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Refuse key file readable by others", func(t *testing.T) {
+		err := os.Chmod(name, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = LoadDateShifter(name, header)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestFullDateShift(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	name := "test-date-shift.csv"
+
+	defer os.Remove(name)
+
+	f := helperFlags()
+	f.dateShift = &name
+
+	innerTest(f, TestFile)
+
+	t.Run("Replace DOB with age in shared outputs", func(t *testing.T) {
+		h := helperCsvHeader(t, "pdl1.csv")
+
+		diff := cmp.Diff(AgeColumn, h[4])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Keep DOB in results.csv", func(t *testing.T) {
+		h := helperCsvHeader(t, "results.csv")
+
+		diff := cmp.Diff("DOB", h[4])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Save key file when the run is committed", func(t *testing.T) {
+		_, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// sortEntry is an entry of an external sort: a record hash and, for input records, the input sequence number and the record.
+type sortEntry struct {
+	Hash [blake2b.Size256]byte
+	Seq  uint64
+	Row  []string
+}
+
+// size estimates the memory used by an entry in bytes.
+func (e *sortEntry) size() (n int64) {
+	n = int64(len(e.Hash)) + 8 + 24
+
+	for _, s := range e.Row {
+		n += int64(len(s)) + 16
+	}
+
+	return n
+}
+
+// sortLess orders entries by hash, then input sequence number.
+func sortLess(a *sortEntry, b *sortEntry) bool {
+	c := bytes.Compare(a.Hash[:], b.Hash[:])
+	if c != 0 {
+		return c < 0
+	}
+
+	return a.Seq < b.Seq
+}
+
+// sortRuns sorts entries to temporary files, each containing a sorted run of entries that fits in a memory budget in bytes.
+// Runs are encrypted if a Crypt that encrypts is given.
+type sortRuns struct {
+	dir    string
+	name   string
+	crypt  *Crypt
+	budget int64
+	size   int64
+	buf    []sortEntry
+	files  []string
+}
+
+// add adds an entry, saving a sorted run once the memory budget is reached.
+func (r *sortRuns) add(e sortEntry) (err error) {
+	r.buf = append(r.buf, e)
+	r.size += e.size()
+
+	if r.size >= r.budget {
+		return r.flush()
+	}
+
+	return nil
+}
+
+// flush sorts buffered entries and saves them as a run.
+func (r *sortRuns) flush() (err error) {
+	if len(r.buf) == 0 {
+		return nil
+	}
+
+	sort.Slice(r.buf, func(i, j int) bool {
+		return sortLess(&r.buf[i], &r.buf[j])
+	})
+
+	f, err := ioutil.TempFile(r.dir, r.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	c, err := r.crypt.Encrypt(w)
+	if err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(c)
+
+	for i := range r.buf {
+		err := enc.Encode(&r.buf[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Close()
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	r.files = append(r.files, f.Name())
+	r.buf = nil
+	r.size = 0
+
+	return f.Close()
+}
+
+// sortReader reads the entries of a sorted run.
+type sortReader struct {
+	f   *os.File
+	dec *gob.Decoder
+	e   sortEntry
+}
+
+// next reads the next entry of a sorted run.
+func (s *sortReader) next() (ok bool, err error) {
+	var e sortEntry
+
+	err = s.dec.Decode(&e)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s.e = e
+
+	return true, nil
+}
+
+// sortMerge merges sorted runs. sortMerge implements heap.Interface.
+type sortMerge []*sortReader
+
+func (m sortMerge) Len() int            { return len(m) }
+func (m sortMerge) Less(i, j int) bool  { return sortLess(&m[i].e, &m[j].e) }
+func (m sortMerge) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *sortMerge) Push(x interface{}) { *m = append(*m, x.(*sortReader)) }
+
+func (m *sortMerge) Pop() interface{} {
+	old := *m
+	s := old[len(old)-1]
+	*m = old[:len(old)-1]
+
+	return s
+}
+
+// merge saves remaining buffered entries and opens the sorted runs for merging.
+func (r *sortRuns) merge() (m *sortMerge, err error) {
+	err = r.flush()
+	if err != nil {
+		return nil, err
+	}
+
+	m = &sortMerge{}
+
+	for _, name := range r.files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := r.crypt.Decrypt(bufio.NewReaderSize(f, 1<<16))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		s := &sortReader{
+			f:   f,
+			dec: gob.NewDecoder(d),
+		}
+
+		ok, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			f.Close()
+			continue
+		}
+
+		*m = append(*m, s)
+	}
+
+	heap.Init(m)
+
+	return m, nil
+}
+
+// next gets the next entry of the merged runs in sort order.
+func (m *sortMerge) next() (e sortEntry, ok bool, err error) {
+	if m.Len() == 0 {
+		return e, false, nil
+	}
+
+	s := (*m)[0]
+	e = s.e
+
+	more, err := s.next()
+	if err != nil {
+		return e, false, err
+	}
+
+	if more {
+		heap.Fix(m, 0)
+	} else {
+		s.f.Close()
+		heap.Pop(m)
+	}
+
+	return e, true, nil
+}
+
+// sortDir creates a temporary directory of sorted runs in the output directory, readable by the owner only.
+// If outputs are encrypted, a Crypt with a key held only in memory is created to encrypt the runs.
+func sortDir(ctx context.Context) (dir string, c *Crypt, err error) {
+	dir, err = ioutil.TempDir(outputsFrom(ctx).Path("."), ".ih-abstract-sort-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if cryptFrom(ctx).encrypting() {
+		c, err = EphemeralCrypt()
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+
+	return dir, c, nil
+}
+
+// sortExisting sorts the record hashes of an existing output file, read from the file, to runs in a temporary directory, encrypted with a Crypt if it encrypts.
+// If rows is set, records are sorted with their sequence number in the file and their values.
+func sortExisting(old *Old, dir string, c *Crypt, budget int64, rows bool) (r *sortRuns, err error) {
+	log.Println("sorting file", old.name, "record hashes")
+
+	r = &sortRuns{dir: dir, name: "old-", crypt: c, budget: budget}
+
+	var counter int64
+
+	_, err = old.each(func(seq uint64, l []string) error {
+		h, err := old.hash(l)
+		if err != nil {
+			return err
+		}
+
+		e := sortEntry{Hash: h}
+		if rows {
+			e.Seq = seq
+			e.Row = l
+		}
+
+		counter++
+
+		return r.add(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("total:", counter, "records in", len(r.files), "sorted runs")
+
+	return r, nil
+}
+
+// ExternalNew identifies new Pathology database records compared to an existing output file, like New, without holding input record hashes in memory.
+// Hashes of existing records and input records are sorted to temporary files in runs that fit in a memory budget, in bytes, then merge-joined.
+// Temporary files are written to a directory in the output directory, readable by the owner only. If outputs are encrypted, temporary files are encrypted with a key held only in memory.
+// For each new record, the corresponding patient identifier to saved to a file.
+// A summary of the new records of each patient identifier is saved to a second file.
+// Records are identified using the identifying columns and normalization of an Identity. Amended records of an existing output file with a natural key are changed, not new.
+func ExternalNew(ctx context.Context, old *Old, header []string, id Identity, budget int64, in chan []string, out chan []string, done chan struct{}) {
+	idCol, err := RecordID(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	idIdx := headerParse(header)[idCol]
+
+	x, err := id.compile(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	go func() {
+		dir, c, err := sortDir(ctx)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// sort existing record hashes
+		existing, err := sortExisting(old, dir, c, budget, false)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// sort input record hashes
+		current := &sortRuns{dir: dir, name: "new-", crypt: c, budget: budget}
+
+		var seq uint64
+
+		for l := range in {
+			i := l
+			out <- i
+
+			amended, err := old.amended(i)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if amended {
+				continue
+			}
+
+			v := x.values(i)
+
+			h, err := hash(&v)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			err = current.add(sortEntry{Hash: h, Seq: seq, Row: i})
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			seq++
+		}
+
+		close(out)
+
+		// merge-join existing and input record hashes
+		mOld, err := existing.merge()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		mNew, err := current.merge()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		log.Println("merging", len(existing.files), "existing and", len(current.files), "input sorted runs")
+
+		n := make(map[string](struct{}))
+		s := NewSummaries(header)
+
+		o, okOld, err := mOld.next()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		var last [blake2b.Size256]byte
+		first := true
+
+		for {
+			e, ok, err := mNew.next()
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if !ok {
+				break
+			}
+
+			// repeated records count once, as the first input record
+			if !first && e.Hash == last {
+				continue
+			}
+
+			first = false
+			last = e.Hash
+
+			for okOld && bytes.Compare(o.Hash[:], e.Hash[:]) < 0 {
+				o, okOld, err = mOld.next()
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			if okOld && o.Hash == e.Hash {
+				continue
+			}
+
+			if idIdx >= len(e.Row) {
+				continue
+			}
+
+			s.Add(e.Row[idIdx], e.Row)
+			n[e.Row[idIdx]] = struct{}{}
+		}
+
+		counter := writeNew(ctx, n, s)
+
+		close(done)
+
+		log.Println("Person-instances with new records:", counter)
+	}()
+}
+
+// externalRemoved passes input records through to an output channel, like removedRecords, then calls a function with each existing record absent from the input, in the order of the existing output file, without holding record hashes in memory.
+// Hashes of input records and existing records are sorted to temporary files in runs that fit in the memory budget of the existing output file, then merge-joined. Removed records are sorted again by their sequence number in the existing output file.
+func externalRemoved(ctx context.Context, old *Old, in chan []string, out chan []string, fn func(l []string)) (err error) {
+	dir, c, err := sortDir(ctx)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// sort input record hashes
+	current := &sortRuns{dir: dir, name: "current-", crypt: c, budget: old.budget}
+
+	for l := range in {
+		i := l
+		out <- i
+
+		if err != nil {
+			continue
+		}
+
+		var h [blake2b.Size256]byte
+
+		h, err = old.hash(i)
+		if err == nil {
+			err = current.add(sortEntry{Hash: h})
+		}
+	}
+
+	close(out)
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("comparing file", old.name, "to current records")
+
+	// sort existing records
+	existing, err := sortExisting(old, dir, c, old.budget, true)
+	if err != nil {
+		return err
+	}
+
+	// merge-join existing and input record hashes
+	mCur, err := current.merge()
+	if err != nil {
+		return err
+	}
+
+	mOld, err := existing.merge()
+	if err != nil {
+		return err
+	}
+
+	// removed records by sequence number
+	removed := &sortRuns{dir: dir, name: "removed-", crypt: c, budget: old.budget}
+
+	e, okCur, err := mCur.next()
+	if err != nil {
+		return err
+	}
+
+	for {
+		o, ok, err := mOld.next()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		for okCur && bytes.Compare(e.Hash[:], o.Hash[:]) < 0 {
+			e, okCur, err = mCur.next()
+			if err != nil {
+				return err
+			}
+		}
+
+		if okCur && e.Hash == o.Hash {
+			continue
+		}
+
+		err = removed.add(sortEntry{Seq: o.Seq, Row: o.Row})
+		if err != nil {
+			return err
+		}
+	}
+
+	m, err := removed.merge()
+	if err != nil {
+		return err
+	}
+
+	for {
+		r, ok, err := m.next()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			break
+		}
+
+		fn(r.Row)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestSortRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sort-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &sortRuns{dir: dir, name: "test-", budget: 256}
+
+	var want [][blake2b.Size256]byte
+
+	for i := 0; i < 100; i++ {
+		l := []string{string(rune('a' + i%26)), string(rune('A' + i))}
+
+		h, err := hash(&l)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, h)
+
+		err = r.add(sortEntry{Hash: h, Seq: uint64(i), Row: l})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Slice(want, func(i, j int) bool {
+		return sortLess(&sortEntry{Hash: want[i]}, &sortEntry{Hash: want[j]})
+	})
+
+	m, err := r.merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Save sorted runs within memory budget", func(t *testing.T) {
+		if len(r.files) < 2 {
+			t.Fatalf("expected multiple sorted runs, got %d", len(r.files))
+		}
+	})
+
+	t.Run("Merge sorted runs", func(t *testing.T) {
+		var got [][blake2b.Size256]byte
+
+		for {
+			e, ok, err := m.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ok {
+				break
+			}
+
+			got = append(got, e.Hash)
+		}
+
+		diff := cmp.Diff(want, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestSortRunsEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sort-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := EphemeralCrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sortRuns{dir: dir, name: "test-", crypt: c, budget: 1 << 20}
+
+	want := []string{"1000000001", "DOE, JANE"}
+
+	h, err := hash(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.add(sortEntry{Hash: h, Row: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := r.merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Encrypt sorted runs", func(t *testing.T) {
+		b, err := ioutil.ReadFile(r.files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(b, []byte(want[1])) {
+			t.Fatal("sorted run is not encrypted")
+		}
+	})
+
+	t.Run("Merge encrypted sorted runs", func(t *testing.T) {
+		e, ok, err := m.next()
+		if !ok || err != nil {
+			t.Fatal("failed to read sorted run")
+		}
+
+		diff := cmp.Diff(want, e.Row)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+// helperNewOutputs reads the new record output files.
+func helperNewOutputs() (outputs map[string]string) {
+	outputs = make(map[string]string)
+
+	for _, name := range []string{"new-ids.txt", "new-ids.csv"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		outputs[name] = string(b)
+	}
+
+	return outputs
+}
+
+func TestExternalNew(t *testing.T) {
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	ctx := withDeterministic(context.Background())
+	header := helperCorrectHeader()
+	old := TestFileOld
+
+	// map-based diff
+	out := make(chan []string, 1000)
+	done := make(chan struct{})
+
+	New(ctx, LoadOld(ctx, old, header, Identity{}, nil, 0).Records(), nil, nil, header, Identity{}, helperTestReader(TestFile), out, done)
+
+	for range out {
+	}
+
+	<-done
+
+	want := helperNewOutputs()
+
+	// external sort diff with a small memory budget
+	out = make(chan []string, 1000)
+	done = make(chan struct{})
+
+	ExternalNew(ctx, LoadOld(ctx, old, header, Identity{}, nil, 512), header, Identity{}, 512, helperTestReader(TestFile), out, done)
+
+	var n int64
+	for range out {
+		n++
+	}
+
+	<-done
+
+	t.Run("Pass through input records", func(t *testing.T) {
+		diff := cmp.Diff(helperCsvLines(TestFile)-1, n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Same output as map-based diff", func(t *testing.T) {
+		diff := cmp.Diff(want, helperNewOutputs())
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
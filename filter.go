@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"regexp"
 	"runtime"
@@ -122,54 +123,98 @@ func Whitespace(s []string) []string {
 	return s
 }
 
-// filterRow filters a row of input data for matches to patterns of interest.
-func filterRow(l []string, colNames map[string]int, pat map[string](*regexp.Regexp), channels map[string](chan []string), counter *int64) {
+// patterns compiles the regular expressions used for filtering.
+func patterns() (pat map[string](*regexp.Regexp)) {
+	pat = make(map[string](*regexp.Regexp))
+
+	pat["pdl1Report"] = regexp.MustCompile(Pdl1Report)
+	pat["msiReport"] = regexp.MustCompile(MsiReport)
+	pat["pdl1Result"] = regexp.MustCompile(Pdl1Result)
+	pat["msiResult"] = regexp.MustCompile(MsiResult)
+
+	return pat
+}
+
+// classify classifies a row of input data by report category: "excluded", "wbc", "cpd", "pdl1", "msi", or "" if the row matches no pattern of interest.
+func classify(l []string, colNames map[string]int, pat map[string](*regexp.Regexp)) string {
 	switch {
 
 	case Exclude(l[colNames["OrderTypeMnemonic"]]):
+		return "excluded"
+
+	case WbcLymph(l[colNames["TestTypeMnemonic"]]):
+		return "wbc"
+
+	case CPD(l[colNames["OrderTypeMnemonic"]]):
+		return "cpd"
+
+	case PDL1(l[colNames["Value"]]):
+		if pat["pdl1Report"].MatchString(l[colNames["Value"]]) {
+			return "pdl1"
+		}
+
+	case MSI(l[colNames["Value"]]):
+		if pat["msiReport"].MatchString(l[colNames["Value"]]) {
+			return "msi"
+		}
+	}
+
+	return ""
+}
+
+// filterRow filters a row of input data for matches to patterns of interest.
+// The category of the row is returned.
+func filterRow(l []string, colNames map[string]int, pat map[string](*regexp.Regexp), channels map[string](chan []string), counter *int64) (category string) {
+	category = classify(l, colNames, pat)
+
+	route(l, category, colNames, pat, channels)
+
+	atomic.AddInt64(counter, 1)
+
+	return category
+}
+
+// route sends a row of input data to the channels of its category.
+func route(l []string, category string, colNames map[string]int, pat map[string](*regexp.Regexp), channels map[string](chan []string)) {
+	switch category {
 
 	// WBC are sent directly to output
 	// WBC are not counted as 'new data'
-	case WbcLymph(l[colNames["TestTypeMnemonic"]]):
+	case "wbc":
 		channels["wbc"] <- l
 		channels["results"] <- l
 
-	case CPD(l[colNames["OrderTypeMnemonic"]]):
+	case "cpd":
 		channels["cpd"] <- l
 		// CPD reports, PD-L1 reports, and MSI reports count
 		// as "new" data and trigger a new report
 		channels["results"] <- l
 		channels["diff"] <- l
 
-	case PDL1(l[colNames["Value"]]):
-		if pat["pdl1Report"].MatchString(l[colNames["Value"]]) {
-			channels["results"] <- l
-			channels["pdl1"] <- l
-			channels["diff"] <- l
+	case "pdl1":
+		channels["results"] <- l
+		channels["pdl1"] <- l
+		channels["diff"] <- l
 
-			pdl1Result := pat["pdl1Result"].FindAllString(l[colNames["Value"]], 10)
-			channels["pdl1-to-diff"] <- Whitespace(pdl1Result)
-		}
+		pdl1Result := pat["pdl1Result"].FindAllString(l[colNames["Value"]], 10)
+		channels["pdl1-to-diff"] <- Whitespace(pdl1Result)
 
-	case MSI(l[colNames["Value"]]):
-		if pat["msiReport"].MatchString(l[colNames["Value"]]) {
-			channels["results"] <- l
-			channels["diff"] <- l
-			channels["msi"] <- l
+	case "msi":
+		channels["results"] <- l
+		channels["diff"] <- l
+		channels["msi"] <- l
 
-			msiResult := pat["msiResult"].FindAllString(l[colNames["Value"]], 10)
-			channels["msi-to-diff"] <- Whitespace(msiResult)
-		}
+		msiResult := pat["msiResult"].FindAllString(l[colNames["Value"]], 10)
+		channels["msi-to-diff"] <- Whitespace(msiResult)
 	}
-
-	atomic.AddInt64(counter, 1)
 }
 
 // filterResults filters a raw data input stream row by row.
-func filterResults(in chan []string, header []string) (results map[string](chan []string), done chan struct{}) {
+// If the context is cancelled, remaining input is drained without filtering.
+func filterResults(ctx context.Context, in chan []string, header []string) (results map[string](chan []string), done chan struct{}) {
 	done = make(chan struct{})
 
-	var buf int64 = 1e7
+	var buf int64 = 1e5
 
 	// channels contains communication of rows
 	// between goroutines processing data
@@ -201,27 +246,41 @@ func filterResults(in chan []string, header []string) (results map[string](chan
 	}
 
 	signal := make(chan struct{}, nProc)
+	nSignals := nProc
 
 	// create patterns to use for filtering
-	pat := make(map[string](*regexp.Regexp))
-
-	pat["pdl1Report"] = regexp.MustCompile(Pdl1Report)
-	pat["msiReport"] = regexp.MustCompile(MsiReport)
-	pat["pdl1Result"] = regexp.MustCompile(Pdl1Result)
-	pat["msiResult"] = regexp.MustCompile(MsiResult)
+	pat := patterns()
 
 	colNames := headerParse(header)
 
-	var counter int64
+	var counter, excluded int64
 
 	// filter records on each core
-	for i := 0; i < nProc; i++ {
+	// in deterministic mode, input order is restored before output
+	if deterministic(ctx) {
+		nSignals = 1
+
+		ordered := filterOrdered(ctx, in, colNames, pat, results, nProc, &counter, &excluded)
+
 		go func() {
-			for l := range in {
-				filterRow(l, colNames, pat, results, &counter)
-			}
+			<-ordered
 			signal <- struct{}{}
 		}()
+	} else {
+		for i := 0; i < nProc; i++ {
+			go func() {
+				for l := range in {
+					if ctx.Err() != nil {
+						continue
+					}
+
+					if filterRow(l, colNames, pat, results, &counter) == "excluded" {
+						atomic.AddInt64(&excluded, 1)
+					}
+				}
+				signal <- struct{}{}
+			}()
+		}
 	}
 
 	stopCounter := make(chan struct{})
@@ -229,7 +288,7 @@ func filterResults(in chan []string, header []string) (results map[string](chan
 
 	// wait and close
 	go func() {
-		for i := 0; i < nProc; i++ {
+		for i := 0; i < nSignals; i++ {
 			<-signal
 		}
 
@@ -237,6 +296,9 @@ func filterResults(in chan []string, header []string) (results map[string](chan
 
 		log.Println("total filtered:", counter, "records")
 
+		manifestFrom(ctx).AddRows("filtered", counter)
+		manifestFrom(ctx).AddRows("excluded", excluded)
+
 		for _, name := range resultTypes {
 			close(results[name])
 		}
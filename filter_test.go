@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -11,7 +12,7 @@ func BenchmarkFilter(b *testing.B) {
 		in := helperTestReader(TestFilePhi)
 
 		header := helperCorrectHeader()
-		out, filterDone := filterResults(in, header)
+		out, filterDone := filterResults(context.Background(), in, header)
 
 		<-filterDone
 
@@ -24,7 +25,7 @@ func TestFilter(t *testing.T) {
 
 	header := helperCorrectHeader()
 
-	out, filterDone := filterResults(in, header)
+	out, filterDone := filterResults(context.Background(), in, header)
 
 	<-filterDone
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// TimeFormat is the canonical ISO-8601 layout used to format date and time values.
+// The layout matches SQL Server .csv exports so that SQL and CSV input hash identically.
+const TimeFormat = "2006-01-02 15:04:05.000"
+
+// DateFormat is the canonical ISO-8601 layout used to format date values without a time component.
+const DateFormat = "2006-01-02"
+
+// timeLayouts are alternative date and time layouts that are converted to TimeFormat.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.0000000",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05.0000000",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.9999999 -07:00",
+}
+
+// formatTime formats a time value using the canonical layout.
+func formatTime(t time.Time, dbType string) string {
+	if dbType == "DATE" {
+		return t.Format(DateFormat)
+	}
+
+	return t.UTC().Format(TimeFormat)
+}
+
+// formatDecimal formats a decimal string canonically by removing insignificant trailing zeros.
+func formatDecimal(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+
+	if s == "" || s == "-" || s == "-0" {
+		return "0"
+	}
+
+	return s
+}
+
+// formatSQL formats a value scanned from an SQL database as a canonical string.
+// NULL values are replaced with a NULL token. Text values are kept as read, like values read from CSV input; values are canonicalized for comparison only.
+func formatSQL(v interface{}, dbType string, null string) string {
+	switch x := v.(type) {
+	case nil:
+		return null
+	case time.Time:
+		return formatTime(x, dbType)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		switch dbType {
+		case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+			return formatDecimal(string(x))
+		case "UNIQUEIDENTIFIER":
+			var u mssql.UniqueIdentifier
+			if err := u.Scan(x); err == nil {
+				return u.String()
+			}
+		}
+		return string(x)
+	case string:
+		return x
+	}
+
+	var s sql.NullString
+	if err := s.Scan(v); err != nil || !s.Valid {
+		return null
+	}
+
+	return s.String
+}
+
+// isDecimal tests if a string is a plain decimal number with a fractional part.
+func isDecimal(s string) bool {
+	if len(s) < 3 {
+		return false
+	}
+
+	dot := false
+
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '-' && i == 0:
+		case c == '.' && !dot && i > 0:
+			dot = true
+		default:
+			return false
+		}
+	}
+
+	return dot
+}
+
+// canonical formats an input string value canonically.
+// Date and time strings are converted to TimeFormat and decimal strings are stripped of trailing zeros.
+// Other strings are returned unchanged.
+func canonical(s string) string {
+	// cheaply exclude most strings before parsing
+	if len(s) > len(DateFormat) && s[4] == '-' && s[7] == '-' && (s[10] == ' ' || s[10] == 'T') {
+		for _, layout := range timeLayouts {
+			t, err := time.Parse(layout, s)
+			if err == nil {
+				return t.UTC().Format(TimeFormat)
+			}
+		}
+
+		return s
+	}
+
+	if isDecimal(s) {
+		return formatDecimal(s)
+	}
+
+	return s
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"canonical datetime":   {input: "2020-11-15 05:28:00.000", want: "2020-11-15 05:28:00.000"},
+		"datetime, no millis":  {input: "2020-11-15 05:28:00", want: "2020-11-15 05:28:00.000"},
+		"datetime2":            {input: "2020-11-15 05:28:00.1230000", want: "2020-11-15 05:28:00.123"},
+		"ISO-8601 T separator": {input: "2020-11-15T05:28:00", want: "2020-11-15 05:28:00.000"},
+		"RFC3339 offset":       {input: "2020-11-15T05:28:00-05:00", want: "2020-11-15 10:28:00.000"},
+		"date only":            {input: "2020-11-15", want: "2020-11-15"},
+		"invalid date":         {input: "1950006-16 00:00:00.000", want: "1950006-16 00:00:00.000"},
+		"decimal":              {input: "10.50", want: "10.5"},
+		"decimal, integral":    {input: "10.00", want: "10"},
+		"negative decimal":     {input: "-0.0", want: "0"},
+		"leading zeros":        {input: "00000111111111", want: "00000111111111"},
+		"free text":            {input: "Tumor proportion score: 1.0%", want: "Tumor proportion score: 1.0%"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, canonical(tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFormatSQL(t *testing.T) {
+	tm := time.Date(2020, 11, 15, 5, 28, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		input  interface{}
+		dbType string
+		want   string
+	}{
+		"NULL":          {input: nil, dbType: "VARCHAR", want: "NULL"},
+		"datetime":      {input: tm, dbType: "DATETIME", want: "2020-11-15 05:28:00.000"},
+		"date":          {input: tm, dbType: "DATE", want: "2020-11-15"},
+		"decimal":       {input: []byte("12.3400"), dbType: "DECIMAL", want: "12.34"},
+		"float":         {input: float64(0.1), dbType: "FLOAT", want: "0.1"},
+		"integer":       {input: int64(42), dbType: "INT", want: "42"},
+		"bit":           {input: true, dbType: "BIT", want: "1"},
+		"string":        {input: "ZZZ, ZZZ", dbType: "NVARCHAR", want: "ZZZ, ZZZ"},
+		"string, bytes": {input: []byte("ZZZ"), dbType: "VARCHAR", want: "ZZZ"},
+		"decimal text":  {input: "2.50", dbType: "VARCHAR", want: "2.50"},
+		"date text":     {input: "2020-11-15T05:28:00", dbType: "NVARCHAR", want: "2020-11-15T05:28:00"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, formatSQL(tc.input, tc.dbType, "NULL"))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
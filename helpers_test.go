@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"log"
+	"os"
+	"testing"
+)
+
+// helperCorrectHeader returns the column names of the test input data.
+func helperCorrectHeader() []string {
+	return []string{"MRN", "MRNFacility", "MedViewPatientID", "PatientName", "DOB", "Sex", "DrawnDate", "DiagServiceID", "AccessionNumber", "HNAMOrderID", "OrderTypeLocalID", "OrderTypeMnemonic", "TestTypeLocalID", "TestTypeMnemonic", "ResultDate", "Value"}
+}
+
+// helperFlags returns command line flags set to their zero values, except for the output directory, which is the current directory, and the output format, which is CSV.
+func helperFlags() (f flags) {
+	var audit, compress, config, dateShift, encryptTo, identity, old, passphrase, pseudonymKey, state, stdout string
+	var deterministic, example, noFilter, runs, scrub, sql bool
+	var keepRuns int
+	var sortMemory int64
+	outputDir := "."
+	outputFormat := CSV
+
+	f.audit = &audit
+	f.compress = &compress
+	f.config = &config
+	f.dateShift = &dateShift
+	f.deterministic = &deterministic
+	f.encryptTo = &encryptTo
+	f.example = &example
+	f.identity = &identity
+	f.keepRuns = &keepRuns
+	f.noFilter = &noFilter
+	f.old = &old
+	f.outputDir = &outputDir
+	f.outputFormat = &outputFormat
+	f.passphrase = &passphrase
+	f.pseudonymKey = &pseudonymKey
+	f.runs = &runs
+	f.scrub = &scrub
+	f.sortMemory = &sortMemory
+	f.sql = &sql
+	f.state = &state
+	f.stdout = &stdout
+
+	return f
+}
+
+// helperTestReader reads a test CSV file and returns a channel of records, excluding the header.
+func helperTestReader(name string) (out chan []string) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	r := readCSV(context.Background(), f)
+
+	go func() {
+		<-r.done
+	}()
+
+	return r.out
+}
+
+// helperCsvLines counts the lines in a file, including the header.
+func helperCsvLines(name string) (lines int64) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	for s.Scan() {
+		lines++
+	}
+
+	return lines
+}
+
+// helperSkipPhi skips a test if restricted PHI-containing test data is unavailable.
+func helperSkipPhi(t *testing.T) {
+	if _, err := os.Stat("./phi"); os.IsNotExist(err) {
+		t.Skip("testdata/phi is unavailable, skipping PHI test")
+	}
+}
+
+// helperCsvHeader reads the header of a CSV file.
+func helperCsvHeader(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	h, err := csv.NewReader(f).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
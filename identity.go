@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Identity defines the columns and per-column value normalization that identify a record for diffing.
+// If no columns are defined, all columns identify a record.
+type Identity struct {
+	Columns   []string            `yaml:"columns"`
+	Normalize map[string][]string `yaml:"normalize"`
+}
+
+// dateLayouts are date and time layouts converted to TimeFormat by the "date" normalizer, in addition to those converted by canonical.
+var dateLayouts = []string{
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+	"01/02/2006",
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"1/2/2006",
+	DateFormat,
+}
+
+// spaces matches runs of whitespace.
+var spaces = regexp.MustCompile(SpacesAndBreaks)
+
+// normalizers are the available value normalization functions.
+var normalizers = map[string](func(string) string){
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"space": func(s string) string {
+		return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+	},
+	"date": func(s string) string {
+		s = strings.TrimSpace(s)
+
+		for _, layout := range dateLayouts {
+			t, err := time.Parse(layout, s)
+			if err == nil {
+				return t.Format(TimeFormat)
+			}
+		}
+
+		return canonical(s)
+	},
+}
+
+// identifier selects and normalizes the identifying values of records with a given header.
+type identifier struct {
+	idx   []int
+	norms [](func(string) string)
+}
+
+// compile creates an identifier for records with a given header.
+func (id Identity) compile(header []string) (x *identifier, err error) {
+	if len(id.Columns) == 0 && len(id.Normalize) == 0 {
+		return nil, nil
+	}
+
+	colNames := headerParse(header)
+
+	x = &identifier{
+		norms: make([](func(string) string), len(header)),
+	}
+
+	for col, names := range id.Normalize {
+		i, ok := colNames[col]
+		if !ok {
+			return nil, errors.New(strings.Join([]string{"normalized column", col, "does not exist in input data"}, " "))
+		}
+
+		var fns [](func(string) string)
+
+		for _, name := range names {
+			fn, ok := normalizers[name]
+			if !ok {
+				return nil, errors.New(strings.Join([]string{"unknown normalizer", name, "for column", col}, " "))
+			}
+
+			fns = append(fns, fn)
+		}
+
+		x.norms[i] = func(s string) string {
+			for _, fn := range fns {
+				s = fn(s)
+			}
+
+			return s
+		}
+	}
+
+	if len(id.Columns) == 0 {
+		for i := range header {
+			x.idx = append(x.idx, i)
+		}
+
+		return x, nil
+	}
+
+	for _, col := range id.Columns {
+		i, ok := colNames[col]
+		if !ok {
+			return nil, errors.New(strings.Join([]string{"identity column", col, "does not exist in input data"}, " "))
+		}
+
+		x.idx = append(x.idx, i)
+	}
+
+	return x, nil
+}
+
+// normalize returns a normalized copy of a record. Values are in canonical form, so that values formatted differently by SQL and CSV inputs compare equal.
+func (x *identifier) normalize(l []string) []string {
+	n := make([]string, len(l))
+
+	for i, s := range l {
+		if x != nil && i < len(x.norms) && x.norms[i] != nil {
+			s = x.norms[i](s)
+		}
+
+		n[i] = canonical(s)
+	}
+
+	return n
+}
+
+// values returns the normalized identifying values of a record.
+func (x *identifier) values(l []string) []string {
+	if x == nil {
+		return l
+	}
+
+	n := x.normalize(l)
+
+	v := make([]string, len(x.idx))
+
+	for i, j := range x.idx {
+		if j < len(n) {
+			v[i] = n[j]
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNormalizers(t *testing.T) {
+	tests := map[string]struct {
+		normalizer string
+		input      string
+		want       string
+	}{
+		"trim":             {normalizer: "trim", input: "1000000007      ", want: "1000000007"},
+		"lower":            {normalizer: "lower", input: "ZZZ, ZZZ", want: "zzz, zzz"},
+		"upper":            {normalizer: "upper", input: "zzz, zzz", want: "ZZZ, ZZZ"},
+		"space":            {normalizer: "space", input: " ZZZ,\n  ZZZ ", want: "ZZZ, ZZZ"},
+		"date (canonical)": {normalizer: "date", input: "2020-11-15 05:28:00", want: "2020-11-15 05:28:00.000"},
+		"date (US)":        {normalizer: "date", input: "11/15/2020 05:28", want: "2020-11-15 05:28:00.000"},
+		"date (invalid)":   {normalizer: "date", input: "1950006-16", want: "1950006-16"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, normalizers[tc.normalizer](tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestIdentityCompile(t *testing.T) {
+	header := []string{"MRN", "PatientName", "Value"}
+
+	t.Run("Default identity uses all columns", func(t *testing.T) {
+		x, err := Identity{}.compile(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l := []string{"1", "ZZZ", "10"}
+
+		diff := cmp.Diff(l, x.values(l))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Select and normalize columns", func(t *testing.T) {
+		id := Identity{
+			Columns:   []string{"MRN", "Value"},
+			Normalize: map[string][]string{"MRN": {"trim"}},
+		}
+
+		x, err := id.compile(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff([]string{"1", "10"}, x.values([]string{"1   ", "ZZZ", "10"}))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Error on unknown column", func(t *testing.T) {
+		_, err := Identity{Columns: []string{"Missing"}}.compile(header)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("Error on unknown normalizer", func(t *testing.T) {
+		_, err := Identity{Normalize: map[string][]string{"MRN": {"missing"}}}.compile(header)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestOldIdentity(t *testing.T) {
+	f := "new.txt"
+
+	id := Identity{
+		Columns:   []string{"MRN", "PatientName"},
+		Normalize: map[string][]string{"MRN": {"trim"}},
+	}
+
+	header := []string{"MRN", "MRNFacility", "MedViewPatientID", "PatientName", "DOB"}
+
+	r := LoadOld(context.Background(), f, header, id, nil, 0).Records()
+
+	x, err := id.compile(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := x.values([]string{"1000000007", "UID", "2222222222", "ZZZ, ZZZ", "1950006-16 00:00:00.000"})
+
+	t.Run("Padded identifier and changed column outside identity are not new", func(t *testing.T) {
+		exists, err := r.Check(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !exists {
+			t.Fatal("record identified as new")
+		}
+	})
+}
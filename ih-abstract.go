@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 
 // ih-abstract streams input raw pathology results to the immune.health.report R package for report generation and quality assurance. The input is .csv data or direct streaming from a Microsoft SQL driver-compatible database. The output is filtered .csv files for incremental new report generation and quality assurance.
@@ -13,6 +16,33 @@ func main() {
 
 	log.Println("ih-abstract starting")
 
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		err := history(historyFlagParse(os.Args[2:]), os.Stdout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "review" {
+		err := review(reviewFlagParse(os.Args[2:]), os.Stdout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		err := audit(auditFlagParse(os.Args[2:]), os.Stdout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		os.Exit(0)
+	}
+
 	f := flagParse()
 
 	if *f.example {
@@ -20,13 +50,23 @@ func main() {
 		os.Exit(0)
 	}
 
-	mainInner(f, os.Stdin)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelOnSignal(cancel)
+
+	mainInner(ctx, f, os.Stdin)
+
+	if ctx.Err() != nil {
+		log.Fatalln("ih-abstract interrupted, outputs are incomplete")
+	}
 }
 
 // mainInner facilitates testing by allowing parameters to be passed to the main program code path.
-func mainInner(f flags, in *os.File) {
+// Cancelling the context stops reading, drains the pipeline, and discards outputs, leaving previous outputs untouched.
+func mainInner(ctx context.Context, f flags, in *os.File) {
 	// parallel process completion signals
-	parallelProcesses := 9
+	parallelProcesses := 15
 	doneSignals := make([]chan struct{}, parallelProcesses)
 
 	// result communication channels
@@ -34,26 +74,213 @@ func mainInner(f flags, in *os.File) {
 	filteredResults := make(map[string](chan []string)) // from filtering
 	msiResults := make(map[string](chan []string))      // msi strings
 	pdl1Results := make(map[string](chan []string))     // pdl1 strings
-	var buf int64 = 2e7
-	diffResults := make(chan []string, buf) // results to diff
+	var diffResults chan []string                       // results to diff
+
+	run := runID()
+
+	// run manifest
+	m := NewManifest(run)
+
+	// raw input data and outputs of the run
+	var r rawRecords
+	var outputs *Outputs
+	var db *SQLiteOutput
+	outputDir := *f.outputDir
+
+	// discard outputs and the SQLite database, and record a failed run in the audit log before exiting
+	fatal := func(err error) {
+		outputs.Abort()
+		db.Discard()
+
+		if *f.audit != "" {
+			m.Finish(false)
+
+			e := NewAuditEntry(m, f, outputDir, r.patients.N())
+			e.Error = err.Error()
+
+			auditErr := AppendAudit(auditPath(*f.audit, *f.outputDir), e)
+			if auditErr != nil {
+				log.Println(auditErr)
+			}
+		}
+
+		log.Fatalln(err)
+	}
+
+	conf, err := runConfig(*f.config)
+	if err != nil {
+		fatal(err)
+	}
+
+	// column projections of outputs
+	err = checkProjections(conf.Columns, conf.Identity, conf.Key)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx = withProjections(ctx, conf.Columns)
+
+	ctx = withManifest(ctx, m)
+
+	if *f.deterministic {
+		ctx = withDeterministic(ctx)
+	}
+
+	// output format of result files
+	err = checkOutputFormat(*f.outputFormat)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx = withOutputFormat(ctx, *f.outputFormat)
+
+	// compression of output files
+	err = checkCompression(*f.compress)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx = withCompression(ctx, *f.compress)
+
+	// sinks of output categories
+	err = checkSinks(conf.Sinks, *f.outputFormat, *f.state != "")
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx = withSinks(ctx, conf.Sinks)
+
+	// output category streamed to stdout
+	category, err := stdoutCategory(conf.Sinks, *f.stdout)
+	if err != nil {
+		fatal(err)
+	}
+
+	var stdout *Stdout
+	if category != "" {
+		stdout = NewStdout(category, os.Stdout)
+		ctx = withStdout(ctx, stdout)
+	}
+
+	// output encryption and input decryption
+	if *f.encryptTo != "" || *f.identity != "" || *f.passphrase != "" {
+		c, err := LoadCrypt(*f.encryptTo, *f.identity, *f.passphrase)
+		if err != nil {
+			fatal(err)
+		}
+
+		ctx = withCrypt(ctx, c)
+	}
+
+	err = checkSQLite(cryptFrom(ctx), *f.outputFormat, conf.Sinks)
+	if err != nil {
+		fatal(err)
+	}
+
+	// output directory, or a timestamped directory for each run
+	var runs *Runs
+	prevDir := outputDir
+
+	if *f.runs {
+		runs, err = OpenRuns(filepath.Join(*f.outputDir, RunsDir))
+		if err != nil {
+			fatal(err)
+		}
+
+		latest, err := runs.Latest()
+		if err != nil {
+			fatal(err)
+		}
+
+		outputDir = runs.Dir(run)
+		prevDir = ""
+
+		if latest != "" {
+			prevDir = runs.Dir(latest)
+		}
+	}
+
+	outputs, err = NewOutputs(outputDir)
+	if err != nil {
+		fatal(err)
+	}
+
+	outputs.prev = prevDir
+	ctx = withOutputs(ctx, outputs)
+
+	// single SQLite database of all outputs, or of outputs with an SQLite sink
+	if *f.outputFormat == SQLite || hasSink(conf.Sinks, SQLite) {
+		db, err = NewSQLiteOutput(ctx, *f.outputFormat == SQLite)
+		if err != nil {
+			fatal(err)
+		}
+
+		ctx = withSQLite(ctx, db)
+	}
+
+	// diff against the CSV results of the previous successful run by default
+	if runs != nil && *f.old == "" {
+		old := previousOutput(ctx, "results.csv")
+		if _, err := os.Stat(old); err == nil {
+			log.Println("using results of the previous run", old, "as --old")
+			f.old = &old
+		}
+	}
+
+	m.Fingerprint, err = fingerprint(conf, *f.noFilter)
+	if err != nil {
+		fatal(err)
+	}
+
+	m.Input.Old = *f.old
+	m.Input.State = *f.state
+
+	// state database of records from previous runs
+	var state *State
+	if *f.state != "" {
+		state, err = OpenState(*f.state, run)
+		if err != nil {
+			fatal(err)
+		}
+		defer state.Close()
+	}
+
+	// secret key for pseudonymization
+	var key []byte
+	if *f.pseudonymKey != "" {
+		key, err = loadKey(*f.pseudonymKey)
+		if err != nil {
+			fatal(err)
+		}
+	}
 
 	// read raw input data
-	r := read(f, in)
+	r = read(ctx, f, in)
 	doneSignals[0] = r.done
 
 	// if no filter
 	// write all results and diff results
-	diffResults = make(chan []string, buf)
 	if *f.noFilter {
-		doneSignals[1] = make(chan struct{})
-		allResults["results"] = make(chan []string, buf)
 		allResults["results"], diffResults, doneSignals[1] = splitCh(r.out)
 	}
 
 	// if immune health filter
 	// write filtered results, diff results, immune health results
 	if !*f.noFilter {
-		filteredResults, doneSignals[2] = filterResults(r.out, r.header)
+		// collect patient names to scrub from unique strings
+		var scrubber *Scrubber
+		in := r.out
+
+		if *f.scrub {
+			scrubber, err = NewScrubber(conf.Scrub)
+			if err != nil {
+				fatal(err)
+			}
+
+			in = scrubber.Names(r.out, r.header)
+		}
+
+		filteredResults, doneSignals[2] = filterResults(ctx, in, r.header)
 		// exclude intermediate channels
 		// containing 'diff' in channel name
 		// these are sent to DiffUnq below, not written out
@@ -67,20 +294,82 @@ func mainInner(f flags, in *os.File) {
 		diffResults = filteredResults["diff"]
 
 		// determine unique strings
-		pdl1Results, doneSignals[3] = DiffUnq(filteredResults["pdl1-to-diff"], "pdl1")
-		msiResults, doneSignals[4] = DiffUnq(filteredResults["msi-to-diff"], "msi")
+		pdl1Results, doneSignals[3] = DiffUnq(ctx, state, scrubber, filteredResults["pdl1-to-diff"], "pdl1")
+		msiResults, doneSignals[4] = DiffUnq(ctx, state, scrubber, filteredResults["msi-to-diff"], "msi")
 
-		// write unique strings
-		doneSignals[5] = Write([]string{"unique-result"}, pdl1Results)
-		doneSignals[6] = Write([]string{"unique-result"}, msiResults)
+		// write unique strings as CSV for review and diffing by the next run
+		// with canonical values of reviewed strings if a state database is provided
+		unqHeader := UniqueHeader
+		if state != nil {
+			unqHeader = UniqueReviewHeader
+		}
+
+		doneSignals[5] = Write(withOutputFormat(ctx, CSV), unqHeader, pdl1Results)
+		doneSignals[6] = Write(withOutputFormat(ctx, CSV), unqHeader, msiResults)
 	}
 
-	// diff
+	// shift dates of outputs shared with analysts
+	// before identifiers are pseudonymized
+	var shifter *DateShifter
+	if *f.dateShift != "" {
+		shifter, err = LoadDateShifter(*f.dateShift, r.header)
+		if err != nil {
+			fatal(err)
+		}
+
+		doneSignals[13] = shifter.Outputs(allResults, SharedOutputs)
+	}
+
+	// pseudonymize identifiers of outputs shared with analysts
+	if key != nil {
+		p := NewPseudonymizer(ctx, key, r.header, conf.Pseudonymize)
+		doneSignals[12] = p.Outputs(allResults, SharedOutputs)
+	}
+
+	// write date shifted outputs, with age at result instead of DOB
+	if shifter != nil {
+		sharedResults := make(map[string](chan []string))
+
+		for _, name := range SharedOutputs {
+			if c, ok := allResults[name]; ok {
+				sharedResults[name] = c
+				delete(allResults, name)
+			}
+		}
+
+		doneSignals[14] = Write(ctx, shifter.Header(r.header), sharedResults)
+	}
+
+	// results of the last run, hashed for all diffs
+	var old *Old
 	if *f.old != "" {
-		allResults["results-increment"], doneSignals[7] = Diff(f.old, diffResults, r.header)
+		old = LoadOld(ctx, *f.old, r.header, conf.Identity, conf.Key, *f.sortMemory<<20)
 	}
 
-	doneSignals[8] = Write(r.header, allResults)
+	// amended records
+	if old != nil && len(conf.Key) > 0 {
+		changedResults := make(map[string](chan []string))
+		diffResults, changedResults["results-changed"], doneSignals[10] = Changed(old, state, diffResults, r.header)
+		doneSignals[11] = Write(ctx, ChangedHeader(conf.Key), changedResults)
+	}
+
+	// diff
+	// or discard records to diff without the results of the last run or a state database
+	if old != nil || state != nil {
+		allResults["results-increment"], doneSignals[7] = Diff(ctx, old, state, conf.Identity, *f.sortMemory<<20, diffResults, r.header)
+	} else {
+		go func() {
+			for range diffResults {
+			}
+		}()
+	}
+
+	// removed records
+	if old != nil {
+		allResults["results"], allResults["results-removed"], doneSignals[9] = Removed(ctx, old, state, allResults["results"], r.header)
+	}
+
+	doneSignals[8] = Write(ctx, r.header, allResults)
 
 	// wait for all parallel processes to finish
 	for _, signal := range doneSignals {
@@ -88,4 +377,75 @@ func mainInner(f flags, in *os.File) {
 			<-signal
 		}
 	}
+
+	m.AddRows("read", atomic.LoadInt64(r.counter))
+
+	// fail if the category streamed to stdout was not written
+	if ctx.Err() == nil {
+		err := stdout.Check()
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// index and save the SQLite database, or discard it if the run was cancelled
+	if db != nil {
+		err := db.Close(ctx, m)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// save date offsets with the outputs of a successful run
+	if shifter != nil && ctx.Err() == nil {
+		err := shifter.Save(ctx)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// replace previous outputs, with the manifest, only after a successful run
+	if ctx.Err() == nil {
+		err = m.Save(ctx, outputs.Path(ManifestFile), true)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = outputs.Commit()
+		if err != nil {
+			fatal(err)
+		}
+
+		// update state database only after outputs are committed
+		if state != nil {
+			err := state.Commit()
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		// point to this run and prune old runs
+		if runs != nil {
+			err = runs.SetLatest(run)
+			if err != nil {
+				fatal(err)
+			}
+
+			_, err = runs.Prune(*f.keepRuns, run)
+			if err != nil {
+				fatal(err)
+			}
+		}
+	} else {
+		outputs.Abort()
+		m.Finish(false)
+	}
+
+	// audit log of runs
+	if *f.audit != "" {
+		err = AppendAudit(auditPath(*f.audit, *f.outputDir), NewAuditEntry(m, f, outputDir, r.patients.N()))
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -58,23 +60,34 @@ func TestMain(m *testing.M) {
 func cleanupTestFull() {
 	testFiles := []string{
 		"cpd.csv",
+		"ih-abstract.sqlite",
 		"msi-unique-strings.csv",
 		"msi-unique-strings-new.csv",
 		"msi.csv",
-		"new-ids.tst",
+		"manifest.json",
+		"new-ids.txt",
+		"new-ids.csv",
+		"removed-ids.txt",
+		"results-removed.csv",
+		"results-changed.csv",
 		"pdl1-unique-strings.csv",
 		"pdl1-unique-strings-new.csv",
 		"pdl1.csv",
+		"pseudonyms.csv",
 		"results-increment.csv",
 		"results.csv",
 		"wbc.csv",
 	}
 
-	for _, f := range testFiles {
-		if _, err := os.Stat(f); err == nil {
-			err := os.Remove(f)
-			if err != nil {
-				log.Fatalln(err)
+	for _, name := range testFiles {
+		for _, ext := range []string{"", CompressedExtensions[Gzip], CompressedExtensions[Zstd]} {
+			for _, f := range []string{name + ext, name + ext + Encrypted} {
+				if _, err := os.Stat(f); err == nil {
+					err := os.Remove(f)
+					if err != nil {
+						log.Fatalln(err)
+					}
+				}
 			}
 		}
 	}
@@ -86,7 +99,7 @@ func innerTest(f flags, newFile string) {
 		log.Fatalln(err)
 	}
 
-	mainInner(f, conn)
+	mainInner(context.Background(), f, conn)
 }
 
 func TestFullFilter(t *testing.T) {
@@ -98,7 +111,7 @@ func TestFullFilter(t *testing.T) {
 	old := TestFileOld
 	sql := false
 
-	var f flags
+	f := helperFlags()
 	f.config = &config
 	f.example = &example
 	f.noFilter = &noFilter
@@ -119,6 +132,8 @@ func TestFullFilter(t *testing.T) {
 		"integration: pdl1-unique-strings.csv":     {input: "pdl1-unique-strings.csv", want: int64(2)},
 		"integration: pdl1.csv":                    {input: "pdl1.csv", want: int64(5)},
 		"integration: results-increment.csv":       {input: "results-increment.csv", want: int64(8)},
+		"integration: results-removed.csv":         {input: "results-removed.csv", want: int64(6)},
+		"integration: removed-ids.txt":             {input: "removed-ids.txt", want: int64(6)},
 		"integration: results.csv":                 {input: "results.csv", want: int64(12)},
 		"integration: wbc.csv":                     {input: "wbc.csv", want: int64(5)},
 	}
@@ -147,7 +162,7 @@ func TestFullNoFilter(t *testing.T) {
 	old := TestFileOld
 	sql := false
 
-	var f flags
+	f := helperFlags()
 	f.config = &config
 	f.example = &example
 	f.noFilter = &noFilter
@@ -162,6 +177,7 @@ func TestFullNoFilter(t *testing.T) {
 		want  int64
 	}{
 		"integration: results-increment.csv": {input: "results-increment.csv", want: int64(13)},
+		"integration: results-removed.csv":   {input: "results-removed.csv", want: int64(6)},
 		"integration: results.csv":           {input: "results.csv", want: int64(13)},
 	}
 
@@ -181,6 +197,8 @@ func TestFullNoFilter(t *testing.T) {
 }
 
 func TestPHIFilter(t *testing.T) {
+	helperSkipPhi(t)
+
 	err := os.Chdir("./phi")
 	if err != nil {
 		log.Fatalln(err)
@@ -211,7 +229,7 @@ func TestPHIFilter(t *testing.T) {
 	old := TestFilePhiOld
 	sql := false
 
-	var f flags
+	f := helperFlags()
 	f.config = &config
 	f.example = &example
 	f.noFilter = &noFilter
@@ -252,6 +270,8 @@ func TestPHIFilter(t *testing.T) {
 }
 
 func TestPHINoFilter(t *testing.T) {
+	helperSkipPhi(t)
+
 	err := os.Chdir("./phi")
 	if err != nil {
 		log.Fatalln(err)
@@ -272,7 +292,7 @@ func TestPHINoFilter(t *testing.T) {
 	old := TestFilePhiGenericOld
 	sql := false
 
-	var f flags
+	f := helperFlags()
 	f.config = &config
 	f.example = &example
 	f.noFilter = &noFilter
@@ -303,3 +323,52 @@ func TestPHINoFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestFullOutputDir(t *testing.T) {
+	dir := "test-output-dir"
+
+	defer os.RemoveAll(dir)
+
+	f := helperFlags()
+	f.outputDir = &dir
+
+	innerTest(f, TestFile)
+
+	// the second run diffs unique strings against the outputs of the first run
+	innerTest(f, TestFile)
+
+	for _, name := range []string{"results.csv", "pdl1-unique-strings.csv", "manifest.json"} {
+		name := name
+
+		t.Run("Write "+name+" readable by the owner only", func(t *testing.T) {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff(os.FileMode(0o600), info.Mode().Perm())
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Diff unique strings against previous outputs in the output directory", func(t *testing.T) {
+		diff := cmp.Diff(int64(1), helperCsvLines(filepath.Join(dir, "pdl1-unique-strings-new.csv")))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Leave no temporary files", func(t *testing.T) {
+		tmp, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(0, len(tmp))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
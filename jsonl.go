@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonlRows writes rows as JSON Lines: one JSON object per row, with the header as keys in header order and values as strings.
+type jsonlRows struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+// newJSONLRows creates a JSON Lines row writer for a header.
+func newJSONLRows(w io.Writer, h []string) (r *jsonlRows, err error) {
+	r = &jsonlRows{w: bufio.NewWriter(w)}
+
+	for _, name := range h {
+		k, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+
+		r.keys = append(r.keys, k)
+	}
+
+	return r, nil
+}
+
+// Write writes a JSON object. Values missing from a row are written as empty strings.
+func (r *jsonlRows) Write(l []string) (err error) {
+	r.w.WriteByte('{')
+
+	for i, k := range r.keys {
+		if i > 0 {
+			r.w.WriteByte(',')
+		}
+
+		var s string
+		if i < len(l) {
+			s = l[i]
+		}
+
+		v, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		r.w.Write(k)
+		r.w.WriteByte(':')
+		r.w.Write(v)
+	}
+
+	r.w.WriteByte('}')
+
+	return r.w.WriteByte('\n')
+}
+
+// Close flushes JSON Lines rows.
+func (r *jsonlRows) Close() error {
+	return r.w.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONLRows(t *testing.T) {
+	tests := map[string]struct {
+		input [][]string
+		want  string
+	}{
+		"Write header as keys":        {input: [][]string{{"1000000001", "Negative"}}, want: `{"MRN":"1000000001","Value":"Negative"}` + "\n"},
+		"Write one object per row":    {input: [][]string{{"1"}, {"2"}}, want: `{"MRN":"1","Value":""}` + "\n" + `{"MRN":"2","Value":""}` + "\n"},
+		"Escape strings":              {input: [][]string{{"1", "a \"b\"\r\nc"}}, want: `{"MRN":"1","Value":"a \"b\"\r\nc"}` + "\n"},
+		"Write missing values empty":  {input: [][]string{{}}, want: `{"MRN":"","Value":""}` + "\n"},
+		"Write nothing without input": {input: nil, want: ""},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			w, err := newJSONLRows(&buf, []string{"MRN", "Value"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, l := range tc.input {
+				err := w.Write(l)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = w.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff(tc.want, buf.String())
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
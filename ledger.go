@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ledgerBuckets are the state database buckets indexing run ledger events by patient identifier and by accession number.
+var ledgerBuckets = map[string]([]byte){
+	"mrn":       []byte("ledger-mrn"),
+	"accession": []byte("ledger-accession"),
+}
+
+// HistoryHeader is the header of run ledger history output.
+var HistoryHeader = []string{"run", "run-completed", "event", "identifier", "accession-number"}
+
+// event is a run ledger event: a record of a patient that appeared, changed, or was removed in a run.
+type event struct {
+	name      string
+	id        string
+	accession string
+}
+
+// Record records a run ledger event for a record with a patient identifier and accession number.
+// Events are saved when the run is committed. Record does nothing if the state database is nil.
+func (s *State) Record(name string, id string, accession string) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	s.events = append(s.events, event{
+		name:      name,
+		id:        strings.TrimSpace(id),
+		accession: strings.TrimSpace(accession),
+	})
+	s.Unlock()
+}
+
+// ledgerKey creates a run ledger index key that sorts by indexed value, then run.
+func ledgerKey(value string, run string, seq uint64) []byte {
+	var k bytes.Buffer
+
+	k.WriteString(value)
+	k.WriteByte(0)
+	k.WriteString(run)
+	k.WriteByte(0)
+
+	err := binary.Write(&k, binary.BigEndian, seq)
+	if err != nil {
+		panic(err)
+	}
+
+	return k.Bytes()
+}
+
+// commitEvents saves pending run ledger events to the state database in a transaction.
+func (s *State) commitEvents(tx *bolt.Tx) (err error) {
+	mrn := tx.Bucket(ledgerBuckets["mrn"])
+	accession := tx.Bucket(ledgerBuckets["accession"])
+
+	for _, e := range s.events {
+		seq, err := mrn.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		v := []byte(strings.Join([]string{e.name, e.id, e.accession}, "\x00"))
+
+		err = mrn.Put(ledgerKey(e.id, s.run, seq), v)
+		if err != nil {
+			return err
+		}
+
+		if e.accession == "" {
+			continue
+		}
+
+		err = accession.Put(ledgerKey(e.accession, s.run, seq), v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// History writes the run ledger events of records with a given patient identifier ("mrn") or accession number ("accession") as CSV.
+func (s *State) History(by string, value string, out io.Writer) (n int64, err error) {
+	w := csv.NewWriter(out)
+
+	err = w.Write(HistoryHeader)
+	if err != nil {
+		return n, err
+	}
+
+	prefix := append([]byte(strings.TrimSpace(value)), 0)
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ledgerBuckets[by])
+		if b == nil {
+			return nil
+		}
+
+		runs := tx.Bucket(runsBucket)
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			run := string(bytes.SplitN(k[len(prefix):], []byte{0}, 2)[0])
+			e := strings.SplitN(string(v), "\x00", 3)
+
+			var completed []byte
+			if runs != nil {
+				completed = runs.Get([]byte(run))
+			}
+
+			err := w.Write([]string{run, string(completed), e[0], e[1], e[2]})
+			if err != nil {
+				return err
+			}
+
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	w.Flush()
+
+	return n, w.Error()
+}
+
+// history writes the run ledger history of a patient identifier or accession number.
+func history(f historyFlags, out io.Writer) (err error) {
+	if *f.state == "" {
+		return errors.New("history requires a state database (--state)")
+	}
+
+	if _, err := os.Stat(*f.state); err != nil {
+		return err
+	}
+
+	by, value := "mrn", *f.mrn
+	if *f.accession != "" {
+		by, value = "accession", *f.accession
+	}
+
+	if value == "" {
+		return errors.New("history requires a patient identifier (--mrn) or accession number (--accession)")
+	}
+
+	s, err := OpenStateReadOnly(*f.state)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	n, err := s.History(by, value, out)
+	if err != nil {
+		return err
+	}
+
+	log.Println("history:", n, "events")
+
+	return nil
+}
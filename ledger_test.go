@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLedger(t *testing.T) {
+	name := "test-ledger.db"
+
+	defer os.Remove(name)
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	s, err := OpenState(name, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helperStateNew(s)
+
+	err = s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.run = "run2"
+	s.Record("removed", "1000000008      ", "00000111111111")
+
+	err = s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("List history by patient identifier", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		n, err := s.History("mrn", "1000000008", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(2), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var events []string
+		for _, l := range rows[1:] {
+			events = append(events, l[0]+" "+l[2])
+		}
+
+		diff = cmp.Diff([]string{"run1 appeared", "run2 removed"}, events)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("List history by accession number", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		n, err := s.History("accession", "00000111111111", &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(13), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	s.Close()
+
+	t.Run("Require an identifier", func(t *testing.T) {
+		state, empty := name, ""
+		f := historyFlags{state: &state, mrn: &empty, accession: &empty}
+
+		err := history(f, &bytes.Buffer{})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("List history while the state database is read", func(t *testing.T) {
+		r, err := OpenStateReadOnly(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		state, mrn, empty := name, "1000000008", ""
+		f := historyFlags{state: &state, mrn: &mrn, accession: &empty}
+
+		var buf bytes.Buffer
+
+		err = history(f, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(3, len(rows))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// version is the ih-abstract version, set at build time.
+var version = "dev"
+
+// ManifestFile is the name of the run manifest output file.
+const ManifestFile = "manifest.json"
+
+// Input describes the input data source of a run.
+type Input struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256,omitempty"`
+	Query  string `json:"query-sha256,omitempty"`
+	Old    string `json:"old,omitempty"`
+	State  string `json:"state,omitempty"`
+}
+
+// Output describes an output file of a run.
+type Output struct {
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest is a machine-readable record of a run: version, start and end times, input source, configuration fingerprint, row counts, and output file checksums.
+// Output consumers should refuse outputs of runs that are not complete or that have an unexpected configuration fingerprint.
+type Manifest struct {
+	Version     string            `json:"version"`
+	Run         string            `json:"run"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Complete    bool              `json:"complete"`
+	Input       Input             `json:"input"`
+	Fingerprint string            `json:"config-fingerprint"`
+	Rows        map[string]int64  `json:"rows"`
+	Outputs     map[string]Output `json:"outputs"`
+	sync.Mutex  `json:"-"`
+}
+
+// NewManifest creates a run manifest.
+func NewManifest(run string) (m *Manifest) {
+	return &Manifest{
+		Version: version,
+		Run:     run,
+		Start:   time.Now().UTC(),
+		Rows:    make(map[string]int64),
+		Outputs: make(map[string]Output),
+	}
+}
+
+// manifestKey is the context key of a run manifest.
+type manifestKey struct{}
+
+// withManifest returns a context carrying a run manifest.
+func withManifest(ctx context.Context, m *Manifest) context.Context {
+	return context.WithValue(ctx, manifestKey{}, m)
+}
+
+// manifestFrom gets the run manifest of a context, or nil if there is none.
+func manifestFrom(ctx context.Context) *Manifest {
+	m, _ := ctx.Value(manifestKey{}).(*Manifest)
+
+	return m
+}
+
+// AddRows adds to a row count. AddRows does nothing if the manifest is nil.
+func (m *Manifest) AddRows(name string, n int64) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.Rows[name] += n
+	m.Unlock()
+}
+
+// SetInput sets the input data source. SetInput does nothing if the manifest is nil.
+func (m *Manifest) SetInput(source string, sum string, query string) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.Input.Source = source
+	m.Input.SHA256 = sum
+	m.Input.Query = query
+	m.Unlock()
+}
+
+// AddOutput adds an output file. AddOutput does nothing if the manifest is nil.
+func (m *Manifest) AddOutput(name string, rows int64, sum []byte) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.Outputs[name] = Output{
+		Rows:   rows,
+		SHA256: hex.EncodeToString(sum),
+	}
+	m.Unlock()
+}
+
+// fingerprint creates a fingerprint of the configuration and filtering rules of a run.
+// Database credentials are excluded.
+func fingerprint(conf confVars, noFilter bool) (s string, err error) {
+	conf.Username = ""
+	conf.Password = ""
+
+	y, err := yaml.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	h.Write(y)
+
+	if !noFilter {
+		for _, rule := range []string{Pdl1Report, MsiReport, Pdl1Result, MsiResult, SpacesAndBreaks} {
+			h.Write([]byte(rule))
+			h.Write([]byte{0})
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Finish records the end time of the run and whether it completed.
+func (m *Manifest) Finish(complete bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.End = time.Now().UTC()
+	m.Complete = complete
+}
+
+// Save completes the manifest and writes it to a temporary file, readable by the owner only, that replaces the manifest file with the outputs of the run when the run is committed.
+func (m *Manifest) Save(ctx context.Context, name string, complete bool) (err error) {
+	m.Finish(complete)
+
+	m.Lock()
+	j, err := json.MarshalIndent(m, "", "  ")
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := tempFile(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(j, '\n'))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return outputsFrom(ctx).add(f.Name(), name)
+}
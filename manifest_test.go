@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManifest(t *testing.T) {
+	m := NewManifest("run1")
+	ctx := withManifest(context.Background(), m)
+
+	name := "test-manifest.csv"
+	defer os.Remove(name)
+
+	w := File(ctx, name, []string{"MRN"})
+
+	err := w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Record output row count and checksum", func(t *testing.T) {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Output{Rows: 1, SHA256: fmt.Sprintf("%x", sha256.Sum256(b))}
+
+		diff := cmp.Diff(want, m.Outputs[name])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	defer os.Remove("test-manifest.json")
+
+	err = m.Save(context.Background(), "test-manifest.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Save manifest", func(t *testing.T) {
+		b, err := ioutil.ReadFile("test-manifest.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var saved Manifest
+
+		err = json.Unmarshal(b, &saved)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !saved.Complete || saved.Run != "run1" || saved.Outputs[name].Rows != 1 {
+			t.Fatal("failed to save manifest")
+		}
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	a, err := fingerprint(confVars{Query: "SELECT", Password: "a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fingerprint(confVars{Query: "SELECT", Password: "b"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := fingerprint(confVars{Query: "SELECT", Key: []string{"MRN"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Exclude credentials from fingerprint", func(t *testing.T) {
+		diff := cmp.Diff(a, b)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Fingerprint configuration", func(t *testing.T) {
+		if a == c {
+			t.Fatal("configuration change does not change fingerprint")
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Old is an existing output file, e.g. the results of the last run (--old), diffed against input records: new, changed (amended), and removed records, and the import of records to a state database.
+// The file is read once, in the background, to hash its records. Record hashes are held in memory only if the diff is not external, and natural key hashes only if a natural key is given. Records are not held in memory; processes that need them read the file again.
+// Records are read with the columns of the input header, matched by name, so that files written with a different column order compare correctly. Columns absent from the file are empty; the columns of the file are kept, so that columns absent from the file are not compared.
+// Records are identified using the identifying columns and normalization of an Identity, and by natural key if one is given.
+type Old struct {
+	ctx      context.Context
+	name     string
+	header   []string
+	cols     []string
+	x        *identifier
+	key      []int
+	budget   int64
+	external bool
+	store    Store
+	keys     map[[blake2b.Size256]byte]oldKey
+	done     chan struct{}
+}
+
+// oldKey is the record hash and sequence number of the last existing record with a natural key.
+type oldKey struct {
+	hash [blake2b.Size256]byte
+	seq  uint64
+}
+
+// LoadOld reads the record hashes of an existing output file for input data with a header.
+// If a memory budget in bytes is given, the diff is external: record hashes are not held in memory, and records are sorted to temporary files in runs that fit in the budget instead.
+func LoadOld(ctx context.Context, name string, header []string, id Identity, key []string, budget int64) (o *Old) {
+	x, err := id.compile(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var idx []int
+	if len(key) > 0 {
+		idx, err = keyIndices(header, key)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	o = &Old{
+		ctx:      ctx,
+		name:     name,
+		header:   header,
+		x:        x,
+		key:      idx,
+		budget:   budget,
+		external: budget > 0,
+		store:    make(Store),
+		keys:     make(map[[blake2b.Size256]byte]oldKey),
+		done:     make(chan struct{}),
+	}
+
+	// an external diff without a natural key holds nothing in memory
+	if o.external && o.key == nil {
+		close(o.done)
+		return o
+	}
+
+	go func() {
+		log.Println("reading file", name)
+
+		var n int64
+
+		cols, err := o.each(func(seq uint64, row []string) error {
+			n++
+
+			h, err := o.hash(row)
+			if err != nil {
+				return err
+			}
+
+			if !o.external {
+				o.store[h] = struct{}{}
+			}
+
+			if o.key == nil {
+				return nil
+			}
+
+			kh, err := o.keyHash(row)
+			if err != nil {
+				return err
+			}
+
+			// the last existing record with a natural key is used for comparison
+			o.keys[kh] = oldKey{hash: h, seq: seq}
+
+			return nil
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		o.cols = cols
+
+		log.Println("total:", n, "records in", name)
+
+		close(o.done)
+	}()
+
+	return o
+}
+
+// each reads the records of the existing output file with the columns of the input header and calls a function with the sequence number of each record in the file.
+// each returns the columns of the file.
+func (o *Old) each(fn func(seq uint64, row []string) error) (cols []string, err error) {
+	f, err := openInput(o.ctx, o.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := readCSV(o.ctx, f)
+
+	go func() {
+		<-r.done
+	}()
+
+	// input columns in the old file
+	colNames := headerParse(r.header)
+	idx := make([]int, len(o.header))
+
+	for i, c := range o.header {
+		j, ok := colNames[c]
+		if !ok {
+			j = -1
+		}
+
+		idx[i] = j
+	}
+
+	var seq uint64
+
+	for l := range r.out {
+		if err != nil {
+			continue
+		}
+
+		row := make([]string, len(o.header))
+
+		for i, j := range idx {
+			if j >= 0 && j < len(l) {
+				row[i] = l[j]
+			}
+		}
+
+		err = fn(seq, row)
+		seq++
+	}
+
+	return r.header, err
+}
+
+// wait waits until the record hashes of the existing output file are read.
+func (o *Old) wait() {
+	<-o.done
+}
+
+// hash hashes the identifying values of a record with the input header.
+func (o *Old) hash(l []string) (h [blake2b.Size256]byte, err error) {
+	v := o.x.values(l)
+
+	return hash(&v)
+}
+
+// keyHash hashes the natural key of a record with the input header.
+func (o *Old) keyHash(l []string) (h [blake2b.Size256]byte, err error) {
+	k := keyValues(o.x.normalize(l), o.key)
+
+	return hash(&k)
+}
+
+// Records gets the record hashes of the existing output file, e.g. to identify new records. Records is empty if the diff is external.
+func (o *Old) Records() *Records {
+	o.wait()
+
+	return &Records{Store: o.store}
+}
+
+// amended checks whether a record with the input header is amended: an existing record has the same natural key but different identifying values.
+// A record is not amended if the Old is nil or has no natural key.
+func (o *Old) amended(l []string) (ok bool, err error) {
+	if o == nil || o.key == nil {
+		return false, nil
+	}
+
+	o.wait()
+
+	kh, err := o.keyHash(l)
+	if err != nil {
+		return false, err
+	}
+
+	prev, ok := o.keys[kh]
+	if !ok {
+		return false, nil
+	}
+
+	h, err := o.hash(l)
+	if err != nil {
+		return false, err
+	}
+
+	return h != prev.hash, nil
+}
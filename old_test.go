@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperOldFile writes an existing output file for testing.
+func helperOldFile(t *testing.T, name string, data string) {
+	err := os.WriteFile(name, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOld(t *testing.T) {
+	name := "test-load-old.csv"
+
+	helperOldFile(t, name, "Value,MRN,Extra\na,1000000001,x\nb,1000000002,y\n")
+	defer os.Remove(name)
+
+	o := LoadOld(context.Background(), name, []string{"MRN", "Value", "AccessionNumber"}, Identity{}, nil, 0)
+	o.wait()
+
+	t.Run("Read records with the columns of the input header", func(t *testing.T) {
+		want := [][]string{
+			{"1000000001", "a", ""},
+			{"1000000002", "b", ""},
+		}
+
+		var rows [][]string
+
+		_, err := o.each(func(seq uint64, l []string) error {
+			rows = append(rows, l)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(want, rows)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Identify records by the columns of the input header", func(t *testing.T) {
+		exists, err := o.Records().Check(&[]string{"1000000002", "b", ""})
+		if !exists || err != nil {
+			t.Fatal("failed to check record")
+		}
+	})
+}
+
+func TestOldAmended(t *testing.T) {
+	name := "test-old-amended.csv"
+
+	helperOldFile(t, name, "MRN,AccessionNumber,Value\n1000000001,A1,x\n1000000002,A2,y\n")
+	defer os.Remove(name)
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	header := []string{"MRN", "AccessionNumber", "Value"}
+
+	o := LoadOld(context.Background(), name, header, Identity{}, []string{"AccessionNumber"}, 0)
+
+	tests := map[string]struct {
+		input []string
+		want  bool
+	}{
+		"Amended":   {input: []string{"1000000001", "A1", "z"}, want: true},
+		"Unchanged": {input: []string{"1000000002", "A2", "y"}},
+		"New":       {input: []string{"1000000003", "A3", "y"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := o.amended(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff(tc.want, got)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Exclude amended records from new records", func(t *testing.T) {
+		in := make(chan []string, 3)
+		for _, tc := range tests {
+			in <- tc.input
+		}
+		close(in)
+
+		out := make(chan []string, 3)
+		done := make(chan struct{})
+
+		New(context.Background(), o.Records(), o, nil, header, Identity{}, in, out, done)
+
+		for range out {
+		}
+
+		<-done
+
+		got, err := os.ReadFile("new-ids.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff("identifier\n1000000003\n", string(got))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync/atomic"
+)
+
+// deterministicKey is the context key of deterministic output ordering mode.
+type deterministicKey struct{}
+
+// withDeterministic returns a context enabling deterministic output ordering mode.
+// In deterministic mode, outputs are written in input order and identifier lists are sorted, so that identical runs produce identical outputs.
+func withDeterministic(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deterministicKey{}, true)
+}
+
+// deterministic reports whether deterministic output ordering mode is enabled.
+func deterministic(ctx context.Context) bool {
+	d, _ := ctx.Value(deterministicKey{}).(bool)
+
+	return d
+}
+
+// sortedKeys gets the keys of a set. The keys are sorted in deterministic output ordering mode.
+func sortedKeys(ctx context.Context, m map[string](struct{})) (keys []string) {
+	keys = make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	if deterministic(ctx) {
+		sort.Strings(keys)
+	}
+
+	return keys
+}
+
+// sequenced is a row of input data tagged with its input sequence number and category.
+type sequenced struct {
+	seq      uint64
+	l        []string
+	category string
+}
+
+// filterOrdered filters a raw data input stream on several goroutines.
+// Rows are tagged with input sequence numbers and sent to output channels in input order.
+// If the context is cancelled, remaining input is drained without filtering.
+func filterOrdered(ctx context.Context, in chan []string, colNames map[string]int, pat map[string](*regexp.Regexp), channels map[string](chan []string), nProc int, counter *int64, excluded *int64) (done chan struct{}) {
+	done = make(chan struct{})
+
+	tagged := make(chan sequenced, nProc)
+	classified := make(chan sequenced, nProc)
+
+	// tag rows with input sequence numbers
+	go func() {
+		var seq uint64
+
+		for l := range in {
+			tagged <- sequenced{seq: seq, l: l}
+			seq++
+		}
+
+		close(tagged)
+	}()
+
+	// classify rows on each core
+	signal := make(chan struct{}, nProc)
+
+	for i := 0; i < nProc; i++ {
+		go func() {
+			for s := range tagged {
+				if ctx.Err() == nil {
+					s.category = classify(s.l, colNames, pat)
+				}
+
+				classified <- s
+			}
+			signal <- struct{}{}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < nProc; i++ {
+			<-signal
+		}
+
+		close(classified)
+	}()
+
+	// restore input order
+	go func() {
+		pending := make(map[uint64]sequenced)
+
+		var next uint64
+
+		for s := range classified {
+			pending[s.seq] = s
+
+			for {
+				s, ok := pending[next]
+				if !ok {
+					break
+				}
+
+				delete(pending, next)
+				next++
+
+				if ctx.Err() != nil {
+					continue
+				}
+
+				route(s.l, s.category, colNames, pat, channels)
+
+				if s.category == "excluded" {
+					atomic.AddInt64(excluded, 1)
+				}
+
+				atomic.AddInt64(counter, 1)
+			}
+		}
+
+		close(done)
+	}()
+
+	return done
+}
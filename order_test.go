@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortedKeys(t *testing.T) {
+	m := map[string](struct{}){"3": {}, "1": {}, "2": {}}
+
+	got := sortedKeys(withDeterministic(context.Background()), m)
+
+	diff := cmp.Diff([]string{"1", "2", "3"}, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestFilterOrdered(t *testing.T) {
+	header := helperCorrectHeader()
+	colNames := headerParse(header)
+	pat := patterns()
+
+	// expected results in input order
+	var want [][]string
+	for l := range helperTestReader(TestFile) {
+		switch classify(l, colNames, pat) {
+		case "", "excluded":
+			continue
+		}
+
+		want = append(want, l)
+	}
+
+	ctx := withDeterministic(context.Background())
+
+	out, filterDone := filterResults(ctx, helperTestReader(TestFile), header)
+
+	<-filterDone
+
+	var got [][]string
+	for l := range out["results"] {
+		got = append(got, l)
+	}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+// helperReadOutputs reads output files to compare runs.
+func helperReadOutputs(names []string) (outputs map[string]string) {
+	outputs = make(map[string]string)
+
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		outputs[name] = string(b)
+	}
+
+	return outputs
+}
+
+func TestFullDeterministic(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	old := TestFileOld
+	deterministic := true
+
+	f := helperFlags()
+	f.old = &old
+	f.deterministic = &deterministic
+
+	names := []string{
+		"cpd.csv",
+		"msi.csv",
+		"new-ids.txt",
+		"new-ids.csv",
+		"pdl1.csv",
+		"removed-ids.txt",
+		"results-increment.csv",
+		"results-removed.csv",
+		"results.csv",
+		"wbc.csv",
+	}
+
+	innerTest(f, TestFile)
+	first := helperReadOutputs(names)
+
+	cleanupTestFull()
+
+	innerTest(f, TestFile)
+	second := helperReadOutputs(names)
+
+	diff := cmp.Diff(first, second)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
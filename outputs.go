@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Outputs is the output directory of a run and the output files of the run, written to temporary files pending commit.
+// Previous outputs are replaced only when the run is committed, so that a failed or cancelled run leaves them untouched.
+// Outputs of the previous run are read from the output directory, or from the directory of the previous run if runs are written to separate directories.
+type Outputs struct {
+	dir     string
+	prev    string
+	pending map[string]string // temporary file names by output file name
+	sync.Mutex
+}
+
+// Categories are the output categories written with the output format, named after their output files, e.g. results for results.csv.
+var Categories = []string{"results", "results-increment", "results-changed", "results-removed", "pdl1", "msi", "cpd", "wbc", "pdl1-unique-strings", "pdl1-unique-strings-new", "msi-unique-strings", "msi-unique-strings-new"}
+
+// FileCategories are the output categories written to CSV files by default, whatever the output format: identifier lists, the summary of new identifiers, and the pseudonym crosswalk.
+var FileCategories = []string{"new-ids", "new-ids-summary", "removed-ids", "pseudonyms"}
+
+// checkCategory checks that an output category of the configuration file is one of the given categories.
+func checkCategory(category string, categories []string) (err error) {
+	for _, c := range categories {
+		if c == category {
+			return nil
+		}
+	}
+
+	return errors.New(strings.Join([]string{"unknown output", category, "expected one of", strings.Join(categories, ", ")}, " "))
+}
+
+// readBack reports whether an output is read by the next run to diff against: results and unique strings.
+func readBack(name string) bool {
+	return name == "results" || strings.HasSuffix(name, "-unique-strings")
+}
+
+// NewOutputs creates the outputs of a run in a directory. The directory is created, readable by the owner only, if it does not exist.
+func NewOutputs(dir string) (o *Outputs, err error) {
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	o = &Outputs{
+		dir:     dir,
+		prev:    dir,
+		pending: make(map[string]string),
+	}
+
+	return o, nil
+}
+
+// outputsKey is the context key of the outputs of a run.
+type outputsKey struct{}
+
+// withOutputs returns a context carrying the outputs of a run.
+func withOutputs(ctx context.Context, o *Outputs) context.Context {
+	return context.WithValue(ctx, outputsKey{}, o)
+}
+
+// outputsFrom gets the outputs of a run from a context, or nil if there are none.
+func outputsFrom(ctx context.Context) *Outputs {
+	o, _ := ctx.Value(outputsKey{}).(*Outputs)
+
+	return o
+}
+
+// Path gets the path of an output file in the output directory. Path returns the name unchanged if the outputs are nil.
+func (o *Outputs) Path(name string) string {
+	if o == nil {
+		return name
+	}
+
+	return filepath.Join(o.dir, name)
+}
+
+// Rel gets the path of an output file relative to the output directory, e.g. to name the output in the run manifest. Rel returns the name unchanged if the outputs are nil or the file is outside the output directory.
+func (o *Outputs) Rel(name string) string {
+	if o == nil {
+		return name
+	}
+
+	rel, err := filepath.Rel(o.dir, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return name
+	}
+
+	return rel
+}
+
+// Previous gets the path of an output file of the previous run, or an empty string if there is no previous run. Previous returns the name unchanged if the outputs are nil.
+func (o *Outputs) Previous(name string) string {
+	if o == nil {
+		return name
+	}
+
+	if o.prev == "" {
+		return ""
+	}
+
+	return filepath.Join(o.prev, name)
+}
+
+// previousOutput gets the path of an output file of the previous run.
+// Compressed files are found as well, preferring the compression algorithm of the outputs of this run, and encrypted files are preferred if outputs are encrypted. If no such file exists, the path of the uncompressed file is returned.
+func previousOutput(ctx context.Context, name string) string {
+	o := outputsFrom(ctx)
+
+	f := o.Previous(name)
+	if f == "" {
+		return ""
+	}
+
+	exts := []string{CompressedExtensions[compressionFrom(ctx)], ""}
+	for _, c := range Compressions {
+		exts = append(exts, CompressedExtensions[c])
+	}
+
+	for _, ext := range exts {
+		for _, p := range []string{o.Previous(cryptFrom(ctx).Name(name + ext)), o.Previous(name + ext)} {
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+
+	return f
+}
+
+// tempFile creates a temporary file, readable by the owner only, in the directory of an output file.
+func tempFile(name string) (f *os.File, err error) {
+	return ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp-")
+}
+
+// add adds a completed temporary file to be renamed to an output file when the run is committed.
+// If the outputs are nil, the temporary file is renamed immediately.
+func (o *Outputs) add(tmp string, name string) (err error) {
+	if o == nil {
+		return os.Rename(tmp, name)
+	}
+
+	o.Lock()
+	o.pending[name] = tmp
+	o.Unlock()
+
+	return nil
+}
+
+// replaced is an output file replaced by a commit from a temporary file, and the backup of the previous output file, if any.
+type replaced struct {
+	name    string
+	tmp     string
+	backup  string
+	renamed bool
+}
+
+// Commit renames the temporary files of completed outputs to the output files, replacing previous outputs.
+// Previous outputs are kept as backups until all outputs are renamed. If renaming fails, replaced outputs are restored from their backups, so that previous outputs are left untouched, and the temporary files of outputs remain pending.
+func (o *Outputs) Commit() (err error) {
+	o.Lock()
+	defer o.Unlock()
+
+	names := make([]string, 0, len(o.pending))
+	for name := range o.pending {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var done []replaced
+
+	for _, name := range names {
+		r, err := replace(o.pending[name], name)
+		if err != nil {
+			rollback(done)
+			return err
+		}
+
+		done = append(done, r)
+	}
+
+	for _, r := range done {
+		delete(o.pending, r.name)
+
+		if r.backup == "" {
+			continue
+		}
+
+		err := os.Remove(r.backup)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	log.Println("committed", len(names), "output files to", o.dir)
+
+	return nil
+}
+
+// replace renames a temporary file to an output file, moving a previous output file to a backup first.
+func replace(tmp string, name string) (r replaced, err error) {
+	r.name = name
+	r.tmp = tmp
+
+	if _, err := os.Lstat(name); err == nil {
+		f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".prev-")
+		if err != nil {
+			return r, err
+		}
+		f.Close()
+
+		err = os.Rename(name, f.Name())
+		if err != nil {
+			os.Remove(f.Name())
+			return r, err
+		}
+
+		r.backup = f.Name()
+	}
+
+	err = os.Rename(tmp, name)
+	if err != nil {
+		rollback([]replaced{r})
+		return r, err
+	}
+
+	r.renamed = true
+
+	return r, nil
+}
+
+// rollback moves replaced output files back to their temporary files and restores previous output files from their backups, in reverse order.
+func rollback(done []replaced) {
+	for i := len(done) - 1; i >= 0; i-- {
+		r := done[i]
+
+		if r.renamed {
+			err := os.Rename(r.name, r.tmp)
+			if err != nil {
+				log.Println("failed to roll back", r.name, err)
+				continue
+			}
+		}
+
+		if r.backup == "" {
+			continue
+		}
+
+		err := os.Rename(r.backup, r.name)
+		if err != nil {
+			log.Println("failed to restore", r.name, err)
+		}
+	}
+}
+
+// Abort removes the temporary files of completed outputs, leaving previous outputs untouched. Abort does nothing if the outputs are nil.
+func (o *Outputs) Abort() {
+	if o == nil {
+		return
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	for name, tmp := range o.pending {
+		err := os.Remove(tmp)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+
+		delete(o.pending, name)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withOutputs(context.Background(), o)
+	name := o.Path("test-outputs.csv")
+
+	err = ioutil.WriteFile(name, []byte("MRN\n1000000000\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := File(ctx, "test-outputs.csv", []string{"MRN"})
+
+	err = w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Keep previous output until commit", func(t *testing.T) {
+		diff := cmp.Diff([]string{"1000000000"}, helperColumn(t, name, 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Replace previous output on commit", func(t *testing.T) {
+		diff := cmp.Diff([]string{"1000000001"}, helperColumn(t, name, 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write outputs readable by the owner only", func(t *testing.T) {
+		for _, f := range []string{filepath.Dir(name), name} {
+			info, err := os.Stat(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Mode().Perm()&0o077 != 0 {
+				t.Fatalf("%s has mode %v", f, info.Mode().Perm())
+			}
+		}
+	})
+
+	t.Run("Remove temporary files on abort", func(t *testing.T) {
+		w := File(ctx, "test-outputs.csv", []string{"MRN"})
+		err := w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o.Abort()
+
+		tmp, err := filepath.Glob(filepath.Join(dir, "out", ".*.tmp-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(0, len(tmp))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		diff = cmp.Diff([]string{"1000000001"}, helperColumn(t, name, 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestOutputsRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withOutputs(context.Background(), o)
+
+	// the previous output is replaced first, then the commit fails
+	err = ioutil.WriteFile(o.Path("a.csv"), []byte("MRN\n1000000000\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := File(ctx, "a.csv", []string{"MRN"})
+
+	err = w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.add(o.Path(".b.csv.tmp-missing"), o.Path("b.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.Commit()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	t.Run("Restore previous outputs if commit fails", func(t *testing.T) {
+		diff := cmp.Diff([]string{"1000000000"}, helperColumn(t, o.Path("a.csv"), 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Keep outputs pending if commit fails", func(t *testing.T) {
+		o.Abort()
+
+		files, err := filepath.Glob(filepath.Join(dir, ".*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff([]string(nil), files)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestOutputsRel(t *testing.T) {
+	o := &Outputs{dir: filepath.Join("out", "run")}
+
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"Output file":          {input: o.Path("results.csv"), want: "results.csv"},
+		"Output table":         {input: o.Path(SQLiteFile) + "#results", want: SQLiteFile + "#results"},
+		"Outside output files": {input: filepath.Join("out", "results.csv"), want: filepath.Join("out", "results.csv")},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, o.Rel(tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
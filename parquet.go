@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize is the approximate size of Parquet row groups in bytes. Rows are buffered in memory until a row group is written.
+var parquetRowGroupSize int64 = 64 << 20
+
+// NumericSuffix is the name suffix of the numeric column added for the Value column in Parquet output.
+const NumericSuffix = "Numeric"
+
+// parquetColumn is a Parquet output column: the input column it is derived from and its type.
+type parquetColumn struct {
+	name string
+	idx  int
+	kind string
+}
+
+// Parquet column types. Numeric columns contain values that parse as numbers, and are otherwise null.
+const (
+	parquetString    = "string"
+	parquetTimestamp = "timestamp"
+	parquetDouble    = "double"
+	parquetNumeric   = "numeric"
+)
+
+// parquetSchema gets the Parquet columns of a header.
+// Dates (columns named DOB or ending in 'Date') are timestamps and AgeAtResult is numeric. The Value column is kept as a string, with an additional numeric column containing values that parse as numbers. Other columns are strings.
+func parquetSchema(h []string) (cols []parquetColumn) {
+	for i, name := range h {
+		switch {
+		case name == DOBColumn || strings.HasSuffix(name, "Date"):
+			cols = append(cols, parquetColumn{name, i, parquetTimestamp})
+		case name == AgeColumn:
+			cols = append(cols, parquetColumn{name, i, parquetDouble})
+		case name == "Value":
+			cols = append(cols, parquetColumn{name, i, parquetString}, parquetColumn{name + NumericSuffix, i, parquetNumeric})
+		default:
+			cols = append(cols, parquetColumn{name, i, parquetString})
+		}
+	}
+
+	return cols
+}
+
+// metadata gets the Parquet schema metadata of a column.
+func (c parquetColumn) metadata() string {
+	switch c.kind {
+	case parquetTimestamp:
+		return "name=" + c.name + ", type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"
+	case parquetDouble, parquetNumeric:
+		return "name=" + c.name + ", type=DOUBLE, repetitiontype=OPTIONAL"
+	default:
+		return "name=" + c.name + ", type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"
+	}
+}
+
+// parseNumber parses a plain decimal or scientific number. Special values such as NaN or Inf are not numbers.
+func parseNumber(s string) (f float64, ok bool) {
+	s = strings.TrimSpace(s)
+
+	digit := false
+
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case strings.ContainsRune("+-.eE", c):
+		default:
+			return 0, false
+		}
+	}
+
+	if !digit {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return f, true
+}
+
+// value converts an input value to the string representation of a Parquet value, or nil for null.
+// Empty values are null, except in string columns. Dates and numbers that cannot be parsed are null and not ok.
+func (c parquetColumn) value(l []string) (v *string, ok bool) {
+	var s string
+	if c.idx < len(l) {
+		s = l[c.idx]
+	}
+
+	switch c.kind {
+	case parquetTimestamp:
+		if s == "" {
+			return nil, true
+		}
+
+		t, _, ok := parseDate(s)
+		if !ok {
+			return nil, false
+		}
+
+		ms := strconv.FormatInt(t.UnixMilli(), 10)
+
+		return &ms, true
+	case parquetDouble, parquetNumeric:
+		if s == "" {
+			return nil, true
+		}
+
+		f, ok := parseNumber(s)
+		if !ok {
+			return nil, c.kind == parquetNumeric
+		}
+
+		n := strconv.FormatFloat(f, 'g', -1, 64)
+
+		return &n, true
+	default:
+		return &s, true
+	}
+}
+
+// parquetRows writes rows as Parquet with a typed schema, in row groups of bounded size.
+type parquetRows struct {
+	w        *writer.CSVWriter
+	cols     []parquetColumn
+	unparsed int64
+	err      error
+}
+
+// newParquetRows creates a Parquet row writer for a header.
+func newParquetRows(w io.Writer, h []string) (r *parquetRows) {
+	r = &parquetRows{cols: parquetSchema(h)}
+
+	md := make([]string, len(r.cols))
+	for i, c := range r.cols {
+		md[i] = c.metadata()
+	}
+
+	r.w, r.err = writer.NewCSVWriterFromWriter(md, w, 1)
+	if r.err != nil {
+		return r
+	}
+
+	r.w.RowGroupSize = parquetRowGroupSize
+	r.w.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return r
+}
+
+// Write writes a Parquet row.
+func (r *parquetRows) Write(l []string) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	rec := make([]*string, len(r.cols))
+
+	for i, c := range r.cols {
+		v, ok := c.value(l)
+		if !ok {
+			r.unparsed++
+		}
+
+		rec[i] = v
+	}
+
+	return r.w.WriteString(rec)
+}
+
+// Close writes the remaining rows and the Parquet footer.
+func (r *parquetRows) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.unparsed > 0 {
+		log.Println(r.unparsed, "dates or numbers that cannot be parsed written as null")
+	}
+
+	return r.w.WriteStop()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// helperParquetFile is an in-memory Parquet file for reading.
+type helperParquetFile struct {
+	*bytes.Reader
+}
+
+func (helperParquetFile) Write(p []byte) (int, error) { return 0, io.ErrShortWrite }
+func (helperParquetFile) Close() error                { return nil }
+
+func (f helperParquetFile) Open(string) (source.ParquetFile, error) {
+	r := *f.Reader
+	return helperParquetFile{&r}, nil
+}
+
+func (f helperParquetFile) Create(string) (source.ParquetFile, error) {
+	return f, nil
+}
+
+// helperParquetColumn reads the values of a column of a Parquet file.
+func helperParquetColumn(t *testing.T, b []byte, name string) []interface{} {
+	pr, err := reader.NewParquetColumnReader(helperParquetFile{bytes.NewReader(b)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	values, _, _, err := pr.ReadColumnByPath("parquet_go_root\x01"+name, pr.GetNumRows())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return values
+}
+
+func TestParquetRows(t *testing.T) {
+	header := []string{"MRN", "DrawnDate", "Value"}
+
+	var buf bytes.Buffer
+
+	w := newParquetRows(&buf, header)
+
+	for _, l := range [][]string{
+		{"1000000001", "2020-11-15 05:28:00.000", "10.5"},
+		{"1000000002", "not a date", "Negative"},
+		{"1000000003", "", "NaN"},
+	} {
+		err := w.Write(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		input string
+		want  []interface{}
+	}{
+		"Write strings":                      {input: "MRN", want: []interface{}{"1000000001", "1000000002", "1000000003"}},
+		"Write dates as timestamps":          {input: "DrawnDate", want: []interface{}{int64(1605418080000), nil, nil}},
+		"Keep values as strings":             {input: "Value", want: []interface{}{"10.5", "Negative", "NaN"}},
+		"Write values that parse as numbers": {input: "ValueNumeric", want: []interface{}{10.5, nil, nil}},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, helperParquetColumn(t, buf.Bytes(), tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Count unparseable dates", func(t *testing.T) {
+		diff := cmp.Diff(int64(1), w.unparsed)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		ok    bool
+	}{
+		"Parse integers":            {input: "15", ok: true},
+		"Parse decimals":            {input: "-0.5", ok: true},
+		"Parse scientific notation": {input: "1e3", ok: true},
+		"Reject NaN":                {input: "NaN", ok: false},
+		"Reject Inf":                {input: "Inf", ok: false},
+		"Reject text":               {input: "<1%", ok: false},
+		"Reject signs only":         {input: "-", ok: false},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			_, ok := parseNumber(tc.input)
+
+			diff := cmp.Diff(tc.ok, ok)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFullParquet(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	parquet := Parquet
+
+	f := helperFlags()
+	f.outputFormat = &parquet
+
+	// results of other formats are diffed by the next run using a state database
+	state := "test-parquet-state.db"
+	f.state = &state
+
+	defer os.Remove(state)
+
+	innerTest(f, TestFile)
+
+	for _, name := range []string{"results", "results-increment", "pdl1", "msi", "cpd", "wbc"} {
+		defer os.Remove(name + ".parquet")
+	}
+
+	t.Run("Write results as Parquet", func(t *testing.T) {
+		b, err := ioutil.ReadFile("results.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := ioutil.ReadFile(ManifestFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Manifest
+
+		err = json.Unmarshal(j, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int(m.Outputs["results.parquet"].Rows), len(helperParquetColumn(t, b, "MRN")))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write unique strings as CSV", func(t *testing.T) {
+		if _, err := os.Stat("pdl1-unique-strings.csv"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
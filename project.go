@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Projection selects the columns of an output file: either an allowlist (include) or a denylist (exclude) of column names.
+type Projection struct {
+	Include []string `yaml:"include"` // columns to write, in this order
+	Exclude []string `yaml:"exclude"` // columns to omit
+}
+
+// checkProjections checks that projected outputs are output categories written with the output format, that no output has both an allowlist and a denylist of columns, and that outputs read by the next run keep the columns that identify their records.
+// Results keep the identifying columns of an Identity and the columns of a natural key; results cannot be projected if all columns identify a record. Unique strings keep the unique-result column first.
+func checkProjections(p map[string]Projection, id Identity, key []string) (err error) {
+	for name, c := range p {
+		err := checkCategory(name, Categories)
+		if err != nil {
+			return err
+		}
+
+		if len(c.Include) > 0 && len(c.Exclude) > 0 {
+			return errors.New(strings.Join([]string{"output", name, "has both included and excluded columns"}, " "))
+		}
+
+		if !readBack(name) {
+			continue
+		}
+
+		if name == "results" && len(id.Columns) == 0 {
+			return errors.New("output results is identified by all columns to diff the next run, configure identifying columns ('identity') to project it")
+		}
+
+		required := UniqueHeader[:1]
+		if name == "results" {
+			required = append(append([]string{}, id.Columns...), key...)
+		}
+
+		for _, col := range required {
+			if !c.keeps(col) {
+				return errors.New(strings.Join([]string{"output", name, "must keep column", col, "to diff the next run"}, " "))
+			}
+		}
+
+		if name != "results" && len(c.Include) > 0 && c.Include[0] != UniqueHeader[0] {
+			return errors.New(strings.Join([]string{"output", name, "must keep column", UniqueHeader[0], "first to diff the next run"}, " "))
+		}
+	}
+
+	return nil
+}
+
+// keeps reports whether a projection keeps a column.
+func (p Projection) keeps(col string) bool {
+	if len(p.Include) > 0 {
+		for _, c := range p.Include {
+			if c == col {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, c := range p.Exclude {
+		if c == col {
+			return false
+		}
+	}
+
+	return true
+}
+
+// columns gets the projected header and the indexes of projected columns in a header.
+// Included columns must exist in the header. Excluded columns that do not exist are ignored.
+func (p Projection) columns(h []string) (header []string, cols []int, err error) {
+	colNames := headerParse(h)
+
+	if len(p.Include) > 0 {
+		for _, c := range p.Include {
+			i, ok := colNames[c]
+			if !ok {
+				return nil, nil, errors.New(strings.Join([]string{"included column", c, "does not exist"}, " "))
+			}
+
+			header = append(header, c)
+			cols = append(cols, i)
+		}
+
+		return header, cols, nil
+	}
+
+	exclude := make(map[string](struct{}))
+	for _, c := range p.Exclude {
+		exclude[c] = struct{}{}
+	}
+
+	for i, c := range h {
+		if _, ok := exclude[c]; ok {
+			continue
+		}
+
+		header = append(header, c)
+		cols = append(cols, i)
+	}
+
+	return header, cols, nil
+}
+
+// project projects a row to columns. Missing values are empty.
+func project(l []string, cols []int) []string {
+	p := make([]string, len(cols))
+
+	for j, i := range cols {
+		if i < len(l) {
+			p[j] = l[i]
+		}
+	}
+
+	return p
+}
+
+// projectionsKey is the context key of output column projections.
+type projectionsKey struct{}
+
+// withProjections returns a context carrying column projections by output name.
+func withProjections(ctx context.Context, p map[string]Projection) context.Context {
+	return context.WithValue(ctx, projectionsKey{}, p)
+}
+
+// projectionFrom gets the column projection of an output from a context.
+func projectionFrom(ctx context.Context, name string) (p Projection, ok bool) {
+	projections, _ := ctx.Value(projectionsKey{}).(map[string]Projection)
+
+	p, ok = projections[name]
+
+	return p, ok
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProjection(t *testing.T) {
+	header := []string{"MRN", "PatientName", "DOB", "DrawnDate", "Value"}
+	row := []string{"1000000001", "DOE, JANE", "1950-01-01", "2020-11-15", "10"}
+
+	tests := map[string]struct {
+		input      Projection
+		wantHeader []string
+		wantRow    []string
+	}{
+		"Include columns in order":      {input: Projection{Include: []string{"Value", "MRN"}}, wantHeader: []string{"Value", "MRN"}, wantRow: []string{"10", "1000000001"}},
+		"Exclude columns":               {input: Projection{Exclude: []string{"PatientName", "DOB"}}, wantHeader: []string{"MRN", "DrawnDate", "Value"}, wantRow: []string{"1000000001", "2020-11-15", "10"}},
+		"Ignore missing excluded names": {input: Projection{Exclude: []string{"AgeAtResult"}}, wantHeader: header, wantRow: row},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			h, cols, err := tc.input.columns(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff([][]string{tc.wantHeader, tc.wantRow}, [][]string{h, project(row, cols)})
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Reject missing included columns", func(t *testing.T) {
+		_, _, err := Projection{Include: []string{"AgeAtResult"}}.columns(header)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("Reject included and excluded columns", func(t *testing.T) {
+		err := checkProjections(map[string]Projection{"wbc": {Include: []string{"MRN"}, Exclude: []string{"DOB"}}}, Identity{}, nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestCheckProjections(t *testing.T) {
+	id := Identity{Columns: []string{"MRN", "Value"}}
+	key := []string{"AccessionNumber"}
+
+	tests := map[string]struct {
+		input map[string]Projection
+		id    Identity
+		ok    bool
+	}{
+		"Project other outputs":                        {input: map[string]Projection{"wbc": {Exclude: []string{"MRN"}}}, ok: true},
+		"Project results keeping identifying columns":  {input: map[string]Projection{"results": {Exclude: []string{"PatientName"}}}, id: id, ok: true},
+		"Reject results dropping identifying columns":  {input: map[string]Projection{"results": {Exclude: []string{"Value"}}}, id: id},
+		"Reject results dropping natural key columns":  {input: map[string]Projection{"results": {Include: []string{"MRN", "Value"}}}, id: id},
+		"Reject results identified by all columns":     {input: map[string]Projection{"results": {Exclude: []string{"PatientName"}}}},
+		"Project unique strings keeping strings first": {input: map[string]Projection{"pdl1-unique-strings": {Include: []string{"unique-result"}}}, ok: true},
+		"Reject unique strings dropping strings":       {input: map[string]Projection{"pdl1-unique-strings": {Exclude: []string{"unique-result"}}}},
+		"Reject unique strings reordering strings":     {input: map[string]Projection{"msi-unique-strings": {Include: []string{"canonical", "unique-result"}}}},
+		"Reject unknown outputs":                       {input: map[string]Projection{"wbcs": {Exclude: []string{"MRN"}}}},
+		"Reject outputs written to CSV files only":     {input: map[string]Projection{"new-ids": {Exclude: []string{"identifier"}}}},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			err := checkProjections(tc.input, tc.id, key)
+
+			diff := cmp.Diff(tc.ok, err == nil)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFullProjection(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	config := "test-projection.yml"
+
+	defer os.Remove(config)
+
+	err := ioutil.WriteFile(config, []byte("columns:\n  wbc:\n    include: [MRN, DrawnDate, Value]\n  pdl1:\n    exclude: [PatientName, DOB]\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := helperFlags()
+	f.config = &config
+
+	innerTest(f, TestFile)
+
+	tests := map[string]struct {
+		input string
+		want  []string
+	}{
+		"Write included columns of wbc.csv":  {input: "wbc.csv", want: []string{"MRN", "DrawnDate", "Value"}},
+		"Omit excluded columns of pdl1.csv":  {input: "pdl1.csv", want: []string{"MRN", "MRNFacility", "MedViewPatientID", "Sex", "DrawnDate", "DiagServiceID", "AccessionNumber", "HNAMOrderID", "OrderTypeLocalID", "OrderTypeMnemonic", "TestTypeLocalID", "TestTypeMnemonic", "ResultDate", "Value"}},
+		"Write all columns of other outputs": {input: "results.csv", want: helperCsvHeader(t, TestFile)},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, helperCsvHeader(t, tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Project rows of wbc.csv", func(t *testing.T) {
+		for _, v := range helperColumn(t, "wbc.csv", 2) {
+			if v == "" {
+				t.Fatal("missing value in wbc.csv")
+			}
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SharedOutputs are the outputs shared with analysts, in which identifiers are pseudonymized and dates are shifted in de-identification mode.
+var SharedOutputs = []string{"pdl1", "msi", "wbc"}
+
+// PseudonymizedColumns are the identifier columns replaced with pseudonyms if configuration file 'pseudonymize' is undefined.
+var PseudonymizedColumns = []string{"MRN", "PatientName", "MedViewPatientID"}
+
+// CrosswalkFile is the name of the output file mapping pseudonyms to identifiers. The file is readable by the owner only.
+const CrosswalkFile = "pseudonyms.csv"
+
+// CrosswalkHeader is the header of the pseudonym crosswalk output file.
+var CrosswalkHeader = []string{"column", "pseudonym", "value"}
+
+// minKeyLength is the minimum length of a pseudonymization secret key in bytes.
+const minKeyLength = 16
+
+// loadKey loads a pseudonymization secret key from a file.
+func loadKey(name string) (key []byte, err error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key = []byte(strings.TrimSpace(string(b)))
+
+	if len(key) < minKeyLength {
+		return nil, errors.New("pseudonymization key must be at least 16 bytes")
+	}
+
+	return key, nil
+}
+
+// Pseudonymizer replaces identifier columns of records with keyed HMAC-SHA256 pseudonyms, which are stable across runs for the same secret key.
+// Each pseudonym is saved once to a crosswalk file. In deterministic output ordering mode, the crosswalk is collected and saved sorted by column and pseudonym once all outputs are done, so that identical runs produce identical crosswalks.
+type Pseudonymizer struct {
+	key    []byte
+	cols   map[int]string
+	seen   map[string](struct{})
+	sorted bool
+	rows   [][]string
+	w      Sink
+	sync.Mutex
+}
+
+// NewPseudonymizer creates a Pseudonymizer for input data with a given header.
+// Identifier columns that do not exist in the input data are ignored. If no columns are given, PseudonymizedColumns are used.
+func NewPseudonymizer(ctx context.Context, key []byte, header []string, columns []string) (p *Pseudonymizer) {
+	if len(columns) == 0 {
+		columns = PseudonymizedColumns
+	}
+
+	colNames := headerParse(header)
+
+	p = &Pseudonymizer{
+		key:    key,
+		cols:   make(map[int]string),
+		seen:   make(map[string](struct{})),
+		sorted: deterministic(ctx),
+	}
+
+	for _, col := range columns {
+		if i, ok := colNames[col]; ok {
+			p.cols[i] = col
+		}
+	}
+
+	// the crosswalk is never written to the SQLite database output shared with analysts, or streamed to stdout
+	p.w = OutputFile(withStdout(withSQLite(ctx, nil), nil), "pseudonyms", CrosswalkFile, CrosswalkHeader)
+
+	return p
+}
+
+// pseudonym creates the pseudonym of an identifier column value.
+// Values are trimmed so that padding does not change the pseudonym.
+func (p *Pseudonymizer) pseudonym(col string, value string) string {
+	mac := hmac.New(sha256.New, p.key)
+
+	mac.Write([]byte(col))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Row creates a copy of a record with identifier columns replaced with pseudonyms.
+// Empty values are not replaced.
+func (p *Pseudonymizer) Row(l []string) (row []string) {
+	row = make([]string, len(l))
+	copy(row, l)
+
+	for i, col := range p.cols {
+		if i >= len(row) {
+			continue
+		}
+
+		value := strings.TrimSpace(row[i])
+		if value == "" {
+			continue
+		}
+
+		row[i] = p.pseudonym(col, value)
+
+		p.Lock()
+		_, ok := p.seen[row[i]]
+		if !ok {
+			p.seen[row[i]] = struct{}{}
+
+			p.crosswalk([]string{col, row[i], value})
+		}
+		p.Unlock()
+	}
+
+	return row
+}
+
+// crosswalk saves a crosswalk row, or collects it to be saved sorted in deterministic output ordering mode.
+func (p *Pseudonymizer) crosswalk(l []string) {
+	if p.sorted {
+		p.rows = append(p.rows, l)
+		return
+	}
+
+	err := p.w.Write(l)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Outputs replaces the named output channels with channels of pseudonymized records.
+// Records are copied because they are shared with other outputs. The crosswalk file is saved once all named outputs are done.
+func (p *Pseudonymizer) Outputs(channels map[string](chan []string), names []string) (done chan struct{}) {
+	done = make(chan struct{})
+
+	var buf int64 = 1e5
+
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		in, ok := channels[name]
+		if !ok {
+			continue
+		}
+
+		out := make(chan []string, buf)
+		channels[name] = out
+
+		wg.Add(1)
+
+		go func() {
+			for l := range in {
+				out <- p.Row(l)
+			}
+
+			close(out)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+
+		// crosswalk rows in column and pseudonym order
+		sort.Slice(p.rows, func(i, j int) bool {
+			if p.rows[i][0] != p.rows[j][0] {
+				return p.rows[i][0] < p.rows[j][0]
+			}
+
+			return p.rows[i][1] < p.rows[j][1]
+		})
+
+		for _, l := range p.rows {
+			err := p.w.Write(l)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		err := p.w.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		log.Println("pseudonymized identifiers:", len(p.seen))
+
+		close(done)
+	}()
+
+	return done
+}
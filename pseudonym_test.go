@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPseudonymizer(t *testing.T) {
+	defer os.Remove(CrosswalkFile)
+
+	ctx := context.Background()
+	header := helperCorrectHeader()
+	key := []byte("0123456789abcdef")
+
+	l := make([]string, len(header))
+	l[0] = "1000000001      "
+	l[3] = "DOE, JANE"
+
+	p := NewPseudonymizer(ctx, key, header, nil)
+	got := p.Row(l)
+	again := p.Row(l)
+	err := p.w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Replace identifier columns", func(t *testing.T) {
+		if got[0] == l[0] || got[3] == l[3] {
+			t.Fatalf("identifiers not replaced: %v", got)
+		}
+
+		diff := cmp.Diff(l[1:3], got[1:3])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Do not modify the original record", func(t *testing.T) {
+		diff := cmp.Diff("1000000001      ", l[0])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Stable pseudonyms for the same key", func(t *testing.T) {
+		q := &Pseudonymizer{key: key}
+
+		diff := cmp.Diff(got[0], q.pseudonym("MRN", "1000000001"))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Different pseudonyms for a different key", func(t *testing.T) {
+		q := &Pseudonymizer{key: []byte("fedcba9876543210")}
+
+		if got[0] == q.pseudonym("MRN", "1000000001") {
+			t.Fatal("pseudonym does not depend on key")
+		}
+	})
+
+	t.Run("Save each pseudonym to an owner-only crosswalk", func(t *testing.T) {
+		diff := cmp.Diff(got, again)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		diff = cmp.Diff(int64(3), helperCsvLines(CrosswalkFile))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		fi, err := os.Stat(CrosswalkFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff = cmp.Diff(os.FileMode(0o600), fi.Mode().Perm())
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestPseudonymizerDeterministic(t *testing.T) {
+	defer os.Remove(CrosswalkFile)
+
+	ctx := withDeterministic(context.Background())
+	header := helperCorrectHeader()
+	key := []byte("0123456789abcdef")
+
+	record := func(mrn string) []string {
+		l := make([]string, len(header))
+		l[0] = mrn
+
+		return l
+	}
+
+	// crosswalk of records split between outputs in a given order
+	crosswalk := func(mrns []string) string {
+		channels := make(map[string](chan []string))
+
+		for i, name := range SharedOutputs {
+			c := make(chan []string, len(mrns))
+			for j, mrn := range mrns {
+				if j%len(SharedOutputs) == i {
+					c <- record(mrn)
+				}
+			}
+			close(c)
+
+			channels[name] = c
+		}
+
+		p := NewPseudonymizer(ctx, key, header, nil)
+		done := p.Outputs(channels, SharedOutputs)
+
+		for _, c := range channels {
+			for range c {
+			}
+		}
+
+		<-done
+
+		b, err := os.ReadFile(CrosswalkFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(b)
+	}
+
+	mrns := []string{"1000000001", "1000000002", "1000000003", "1000000004", "1000000005", "1000000006"}
+	want := crosswalk(mrns)
+
+	t.Run("Save the crosswalk sorted", func(t *testing.T) {
+		rows, err := csv.NewReader(strings.NewReader(want)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(true, sort.SliceIsSorted(rows[1:], func(i, j int) bool { return rows[i+1][1] < rows[j+1][1] }))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Save identical crosswalks regardless of output order", func(t *testing.T) {
+		reversed := make([]string, len(mrns))
+		for i, mrn := range mrns {
+			reversed[len(mrns)-1-i] = mrn
+		}
+
+		diff := cmp.Diff(want, crosswalk(reversed))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	name := "test-key"
+
+	defer os.Remove(name)
+
+	err := ioutil.WriteFile(name, []byte("short\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadKey(name)
+	if err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
+
+// helperColumn reads a column of a CSV file, excluding the header.
+func helperColumn(t *testing.T, name string, i int) (values []string) {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range rows[1:] {
+		values = append(values, l[i])
+	}
+
+	return values
+}
+
+func TestFullPseudonymize(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	name := "test-key"
+
+	defer os.Remove(name)
+
+	err := ioutil.WriteFile(name, []byte("0123456789abcdef\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := TestFileOld
+
+	f := helperFlags()
+	f.old = &old
+	f.pseudonymKey = &name
+
+	innerTest(f, TestFile)
+
+	mrn := make(map[string](struct{}))
+	for _, v := range helperColumn(t, TestFile, 0) {
+		mrn[v] = struct{}{}
+	}
+
+	for _, output := range []string{"pdl1.csv", "msi.csv", "wbc.csv"} {
+		output := output
+
+		t.Run("Pseudonymize "+output, func(t *testing.T) {
+			for _, v := range helperColumn(t, output, 0) {
+				if _, ok := mrn[v]; ok {
+					t.Fatalf("identifier %q in %s", v, output)
+				}
+			}
+		})
+	}
+
+	t.Run("Keep real identifiers in results.csv", func(t *testing.T) {
+		for _, v := range helperColumn(t, "results.csv", 0) {
+			if _, ok := mrn[v]; !ok {
+				t.Fatalf("pseudonym %q in results.csv", v)
+			}
+		}
+	})
+
+	t.Run("Keep real identifiers in new-ids.txt", func(t *testing.T) {
+		for _, v := range helperColumn(t, "new-ids.txt", 0) {
+			if _, ok := mrn[v]; !ok {
+				t.Fatalf("pseudonym %q in new-ids.txt", v)
+			}
+		}
+	})
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -12,7 +15,7 @@ import (
 )
 
 // read reads raw input data.
-func read(f flags, in *os.File) (r rawRecords) {
+func read(ctx context.Context, f flags, in *os.File) (r rawRecords) {
 	log.Println("initializing records map")
 
 	if *f.sql {
@@ -24,21 +27,36 @@ func read(f flags, in *os.File) (r rawRecords) {
 		}
 		defer db.Close()
 
-		r = DB(*f.config, db)
+		r = DB(ctx, *f.config, db)
 	}
 
 	if !(*f.sql) {
 		log.Println("reading Stdin")
 
-		r = readCSV(in)
+		sum := sha256.New()
+
+		r = readCSV(ctx, io.TeeReader(in, sum))
+
+		// checksum input once reading is done
+		readDone := r.done
+		done := make(chan struct{})
+
+		go func() {
+			<-readDone
+			manifestFrom(ctx).SetInput("stdin", fmt.Sprintf("%x", sum.Sum(nil)), "")
+			done <- struct{}{}
+		}()
+
+		r.done = done
 	}
 
 	return r
 }
 
 // readSQLRows reads rows of strings from an SQL database.
-func readSQLRows(rows *sql.Rows) (r rawRecords) {
-	var buf int64 = 2e7
+// Values are scanned using their database type and formatted canonically. NULL values are replaced with a NULL token.
+func readSQLRows(ctx context.Context, rows *sql.Rows, null string) (r rawRecords) {
+	var buf int64 = 1e5
 
 	// initialize channels
 	r.out = make(chan []string, buf)
@@ -50,15 +68,23 @@ func readSQLRows(rows *sql.Rows) (r rawRecords) {
 		log.Fatalln(err)
 	}
 
-	// slice of byte slices of correct length
-	rawResult := make([]sql.NullString, len(r.header))
-	// string slice of correct length
-	result := make([]string, len(r.header))
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	dbTypes := make([]string, len(types))
+	for i, t := range types {
+		dbTypes[i] = t.DatabaseTypeName()
+	}
+
+	// typed values of correct length
+	rawResult := make([]interface{}, len(r.header))
 	// destination interface
 	dest := make([]interface{}, len(r.header))
 
 	// add pointers to destination
-	for i := range result {
+	for i := range rawResult {
 		dest[i] = &rawResult[i]
 	}
 
@@ -66,6 +92,9 @@ func readSQLRows(rows *sql.Rows) (r rawRecords) {
 	stopCounter := make(chan struct{})
 	count(&counter, "read (sql)", stopCounter)
 
+	r.counter = &counter
+	r.patients = NewPatients(r.header)
+
 	go func() {
 		for rows.Next() {
 			// fill destination
@@ -76,18 +105,24 @@ func readSQLRows(rows *sql.Rows) (r rawRecords) {
 
 			counter++
 
+			// a new slice is required for each row
+			// because rows are consumed concurrently
+			result := make([]string, len(r.header))
+
 			for i, raw := range rawResult {
-				// handle nil type with conversion to ""
-				if raw.Valid {
-					result[i] = raw.String
-				}
+				result[i] = formatSQL(raw, dbTypes[i], null)
 			}
 
+			r.patients.Add(result)
+
 			r.out <- result
 		}
 
 		err = rows.Err()
-		if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			log.Println("reading (sql) cancelled:", ctx.Err())
+		case err != nil:
 			log.Fatalln("error encountered during iteration:", rows.Err())
 		}
 
@@ -100,8 +135,9 @@ func readSQLRows(rows *sql.Rows) (r rawRecords) {
 }
 
 // readCSV reads records from a CSV file.
-func readCSV(in io.Reader) (r rawRecords) {
-	var buf int64 = 2e7
+// Reading stops early if the context is cancelled.
+func readCSV(ctx context.Context, in io.Reader) (r rawRecords) {
+	var buf int64 = 1e5
 
 	// initialize channels
 	r.out = make(chan []string, buf)
@@ -120,23 +156,38 @@ func readCSV(in io.Reader) (r rawRecords) {
 	stopCounter := make(chan struct{})
 	count(&counter, "read (csv)", stopCounter)
 
+	r.counter = &counter
+	r.patients = NewPatients(r.header)
+
 	// process records
 	go func() {
 		for {
 			l, err := reader.Read()
 
-			counter++
-
 			switch {
+			case ctx.Err() != nil:
+				log.Println("reading (csv) cancelled:", ctx.Err())
+
+				r.done <- struct{}{}
+
+				close(r.out)
+
+				return
+
 			case errors.Is(err, io.EOF):
 				r.done <- struct{}{}
 
 				close(r.out)
 
+				return
+
 			case err != nil:
 				log.Fatal(err)
 
 			default:
+				counter++
+				r.patients.Add(l)
+
 				r.out <- l
 			}
 		}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -14,7 +17,7 @@ func TestReadCSV(t *testing.T) {
 "1000000001      ","UID",1111111111,"ZZZ, ZZZ",1950006-16 00:00:00.000,M,2020-11-15 05:28:00.000,GL,00000111111111,1111111111,1111111111,CMV,1111111111111,WBC,2014-11-15 05:37:58.000,Test removal on basis of Order
 `)
 
-	r := readCSV(mock)
+	r := readCSV(context.Background(), mock)
 
 	<-r.done
 
@@ -31,6 +34,22 @@ func TestReadCSV(t *testing.T) {
 			t.Fatalf(diff)
 		}
 	})
+
+	t.Run("Keep CSV values as read", func(t *testing.T) {
+		r := readCSV(context.Background(), strings.NewReader("MRN,Value\n1000000001,2.50\n"))
+
+		<-r.done
+
+		var got [][]string
+		for l := range r.out {
+			got = append(got, l)
+		}
+
+		diff := cmp.Diff([][]string{{"1000000001", "2.50"}}, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
 }
 
 func BenchmarkReadCSV(b *testing.B) {
@@ -38,7 +57,7 @@ func BenchmarkReadCSV(b *testing.B) {
 "1000000001      ","UID",1111111111,"ZZZ, ZZZ",1950006-16 00:00:00.000,M,2020-11-15 05:28:00.000,GL,00000111111111,1111111111,1111111111,CMV,1111111111111,WBC,2014-11-15 05:37:58.000,Test removal on basis of Order
 `)
 
-	r := readCSV(mock)
+	r := readCSV(context.Background(), mock)
 
 	<-r.done
 
@@ -74,7 +93,7 @@ func TestReadSQLRows(t *testing.T) {
 
 	rows, err := db.Query(vars.Query)
 
-	r := readSQLRows(rows)
+	r := readSQLRows(context.Background(), rows, vars.Null)
 
 	var counter int64
 	for range r.out {
@@ -86,8 +105,48 @@ func TestReadSQLRows(t *testing.T) {
 	log.Println(counter)
 }
 
+func TestReadSQLRowsNull(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"MRN", "ResultDate"}).
+		AddRow("1000000001", time.Date(2020, 11, 15, 5, 28, 0, 0, time.UTC)).
+		AddRow("1000000002", nil)
+
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+	sqlRows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := readSQLRows(context.Background(), sqlRows, "NULL")
+
+	var got [][]string
+	for l := range r.out {
+		got = append(got, l)
+	}
+
+	<-r.done
+
+	want := [][]string{
+		{"1000000001", "2020-11-15 05:28:00.000"},
+		{"1000000002", "NULL"},
+	}
+
+	t.Run("Format SQL values and NULL", func(t *testing.T) {
+		diff := cmp.Diff(want, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
 func TestRead(t *testing.T) {
-	var f flags
+	f := helperFlags()
 	sql := false
 	f.sql = &sql
 
@@ -96,7 +155,7 @@ func TestRead(t *testing.T) {
 		log.Fatalln(err)
 	}
 
-	r := read(f, conn)
+	r := read(context.Background(), f, conn)
 	<-r.done
 
 	t.Run("read", func(t *testing.T) {
@@ -107,7 +166,7 @@ func TestRead(t *testing.T) {
 }
 
 func BenchmarkRead(b *testing.B) {
-	var f flags
+	f := helperFlags()
 	sql := false
 	f.sql = &sql
 
@@ -118,7 +177,33 @@ func BenchmarkRead(b *testing.B) {
 			log.Fatalln(err)
 		}
 
-		r := read(f, conn)
+		r := read(context.Background(), f, conn)
 		<-r.done
 	}
 }
+
+func TestReadCSVCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conn, err := os.Open(TestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := readCSV(ctx, conn)
+	<-r.done
+
+	var i int64
+	for range r.out {
+		i++
+	}
+
+	t.Run("stop reading when cancelled", func(t *testing.T) {
+		diff := cmp.Diff(int64(0), i)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
 	"log"
-	"os"
-	"runtime"
 	"strings"
 	"sync"
-	"sync/atomic"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -23,23 +21,38 @@ type Records struct {
 	sync.Mutex
 }
 
-// Add adds a record.
-func (r *Records) Add(l *[]string) (err error) {
-	buf := &bytes.Buffer{}
+// Hashes provides access to a set of record hashes.
+type Hashes interface {
+	Add(l *[]string) error
+	Check(l *[]string) (bool, error)
+}
 
-	var x struct{}
+// hash hashes a record. Values are hashed in canonical form, so that records read from SQL and CSV inputs hash identically while values are output as read.
+func hash(l *[]string) (h [blake2b.Size256]byte, err error) {
+	c := make([]string, len(*l))
+	for i, s := range *l {
+		c[i] = canonical(s)
+	}
 
-	err = gob.NewEncoder(buf).Encode(l)
+	buf := &bytes.Buffer{}
+
+	err = gob.NewEncoder(buf).Encode(&c)
 	if err != nil {
-		return err
+		return h, err
 	}
 
-	bs := buf.Bytes()
+	return blake2b.Sum256(buf.Bytes()), nil
+}
 
-	hash := blake2b.Sum256(bs)
+// Add adds a record.
+func (r *Records) Add(l *[]string) (err error) {
+	hash, err := hash(l)
+	if err != nil {
+		return err
+	}
 
 	r.Lock()
-	r.Store[hash] = x
+	r.Store[hash] = struct{}{}
 	r.Unlock()
 
 	return nil
@@ -47,17 +60,11 @@ func (r *Records) Add(l *[]string) (err error) {
 
 // Check checks that a record exists.
 func (r *Records) Check(l *[]string) (exists bool, err error) {
-	buf := &bytes.Buffer{}
-
-	err = gob.NewEncoder(buf).Encode(l)
+	hash, err := hash(l)
 	if err != nil {
 		return false, err
 	}
 
-	bs := buf.Bytes()
-
-	hash := blake2b.Sum256(bs)
-
 	r.Lock()
 	_, ok := r.Store[hash]
 	r.Unlock()
@@ -65,78 +72,47 @@ func (r *Records) Check(l *[]string) (exists bool, err error) {
 	return ok, nil
 }
 
-// Existing creates a map of existing records.
-func Existing(name *string) (rs *Records) {
-	var records Records
-	records.Store = make(Store)
-
-	rs = &records
-
-	f, err := os.Open(*name)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	log.Println("reading file", *name, "to hash map")
-
-	r := readCSV(f)
-
-	signal := make(chan struct{})
-
-	var counter int64
-	stopCounter := make(chan struct{})
-	count(&counter, "hashed", stopCounter)
-
-	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
-		go func() {
-			for l := range r.out {
-				i := l
-
-				err := rs.Add(&i)
-				if err != nil {
-					log.Fatalln(err)
-				}
-
-				atomic.AddInt64(&counter, 1)
-			}
-			signal <- struct{}{}
-		}()
-	}
-
-	<-r.done
-
-	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
-		<-signal
-	}
-
-	stopCounter <- struct{}{}
-
-	log.Println("total:", counter, "records")
-
-	return rs
-}
-
 // New identifies new Pathology database records based on a record hash.
 // For each new record, the corresponding patient identifier to saved to a file.
-func New(r *Records, header []string, in chan []string, out chan []string, done chan struct{}) {
-	var counter int64
-
+// A summary of the new records of each patient identifier is saved to a second file.
+// New records are added to the record hashes.
+// Records are identified using the identifying columns and normalization of an Identity.
+// If the records of an existing output file with a natural key are given, amended records are changed, not new.
+// New records are recorded in the run ledger of a state database, if provided.
+func New(ctx context.Context, r Hashes, old *Old, state *State, header []string, id Identity, in chan []string, out chan []string, done chan struct{}) {
 	n := make(map[string](struct{}))
-	w := File("new-ids.txt", []string{"identifier"})
 
-	id, err := RecordID(header)
+	idCol, err := RecordID(header)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	colNames := headerParse(header)
-	idIdx := colNames[id]
+	idIdx := colNames[idCol]
+
+	x, err := id.compile(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	s := NewSummaries(header)
 
 	go func() {
 		for l := range in {
 			i := l
 			out <- i
 
-			exists, err := r.Check(&i)
+			amended, err := old.amended(i)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			if amended {
+				continue
+			}
+
+			v := x.values(i)
+
+			exists, err := r.Check(&v)
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -145,6 +121,15 @@ func New(r *Records, header []string, in chan []string, out chan []string, done
 				continue
 			}
 
+			err = r.Add(&v)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			s.Add(l[idIdx], l)
+
+			state.Record("appeared", l[idIdx], column(l, colNames, "AccessionNumber"))
+
 			_, ok := n[l[idIdx]] // do not duplicate person instance output
 
 			if ok {
@@ -154,16 +139,8 @@ func New(r *Records, header []string, in chan []string, out chan []string, done
 			n[l[idIdx]] = struct{}{}
 		}
 
-		for k := range n {
-			err := w.w.Write([]string{k})
-			if err != nil {
-				log.Fatalln(err)
-			}
-
-			atomic.AddInt64(&counter, 1)
-		}
+		counter := writeNew(ctx, n, s)
 
-		w.done()
 		close(out)
 		close(done)
 
@@ -171,6 +148,36 @@ func New(r *Records, header []string, in chan []string, out chan []string, done
 	}()
 }
 
+// writeNew saves patient identifiers with new records to a file, and a summary of the new records of each patient identifier to a second file.
+// Identifiers are sorted in deterministic output ordering mode.
+func writeNew(ctx context.Context, n map[string](struct{}), s *Summaries) (counter int64) {
+	w := OutputFile(ctx, "new-ids", "new-ids.txt", []string{"identifier"})
+	ws := OutputFile(ctx, "new-ids-summary", "new-ids.csv", SummaryHeader)
+
+	for _, k := range sortedKeys(ctx, n) {
+		err := w.Write([]string{k})
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		err = ws.Write(s.Row(k))
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		counter++
+	}
+
+	for _, s := range []Sink{w, ws} {
+		err := s.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	return counter
+}
+
 // RecordID gets a single input data column name containing a person-instance identifier.
 // The person instance identifier is either an MRN (preferred) or UID.
 func RecordID(header []string) (id string, err error) {
@@ -190,21 +197,30 @@ func RecordID(header []string) (id string, err error) {
 }
 
 // Diff diffs old and new record sets.
-func Diff(oldFile *string, in chan []string, header []string) (out chan []string, done chan struct{}) {
-	var buf int64 = 2e7
+// If a state database is provided, records are diffed against the state database and the records of the existing output file, if any, are imported to the state database. Otherwise, records are diffed against the existing output file, using an external sort within a memory budget in bytes if the budget is greater than zero.
+func Diff(ctx context.Context, old *Old, state *State, id Identity, sortMemory int64, in chan []string, header []string) (out chan []string, done chan struct{}) {
+	var buf int64 = 1e5
 	out = make(chan []string, buf)
 	done = make(chan struct{})
 
 	go func() {
-		var records Records
+		if state != nil {
+			if old != nil {
+				state.Import(old)
+			}
 
-		r := &records
+			New(ctx, state, old, state, header, id, in, out, done)
 
-		r.Store = make(Store)
+			return
+		}
 
-		r = Existing(oldFile)
+		if sortMemory > 0 {
+			ExternalNew(ctx, old, header, id, sortMemory, in, out, done)
+
+			return
+		}
 
-		New(r, header, in, out, done)
+		New(ctx, old.Records(), old, nil, header, id, in, out, done)
 	}()
 
 	return out, done
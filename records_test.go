@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"os"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -30,12 +32,28 @@ func TestAddCheckRecords(t *testing.T) {
 			t.Fatal("failed to check record")
 		}
 	})
+
+	t.Run("Check record formatted differently", func(t *testing.T) {
+		l := []string{
+			"10000001",
+			"ZZZ, ZZZ",
+			"CBC",
+			"100.00",
+		}
+
+		r.Add(&[]string{"10000001", "ZZZ, ZZZ", "CBC", "100.0"})
+
+		exists, err := r.Check(&l)
+		if !exists || err != nil {
+			t.Fatal("failed to check record")
+		}
+	})
 }
 
-func TestExistingRecords(t *testing.T) {
+func TestOldRecords(t *testing.T) {
 	f := TestFile
 
-	r := Existing(&f)
+	r := LoadOld(context.Background(), f, helperCorrectHeader(), Identity{}, nil, 0).Records()
 
 	t.Run("existing", func(t *testing.T) {
 		diff := cmp.Diff(int(12), len(r.Store))
@@ -45,13 +63,13 @@ func TestExistingRecords(t *testing.T) {
 	})
 }
 
-func BenchmarkExistingRecords(b *testing.B) {
+func BenchmarkOldRecords(b *testing.B) {
 	f := TestFilePhi
 
 	var r *Records
 
 	for i := 0; i < b.N; i++ {
-		r = Existing(&f)
+		r = LoadOld(context.Background(), f, helperCorrectHeader(), Identity{}, nil, 0).Records()
 	}
 
 	_ = r
@@ -59,7 +77,7 @@ func BenchmarkExistingRecords(b *testing.B) {
 
 func TestNewRecords(t *testing.T) {
 	f := TestFileOld
-	r := Existing(&f)
+	r := LoadOld(context.Background(), f, helperCorrectHeader(), Identity{}, nil, 0).Records()
 
 	in := helperTestReader(TestFile)
 
@@ -69,7 +87,10 @@ func TestNewRecords(t *testing.T) {
 	out := make(chan []string, buf)
 	done := make(chan struct{})
 
-	New(r, header, in, out, done)
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	New(context.Background(), r, nil, nil, header, Identity{}, in, out, done)
 
 	for l := range out {
 		_ = l
@@ -85,6 +106,15 @@ func TestNewRecords(t *testing.T) {
 			t.Fatalf(diff)
 		}
 	})
+
+	t.Run("Summarize new data", func(t *testing.T) {
+		lines := helperCsvLines("new-ids.csv")
+
+		diff := cmp.Diff(int64(11), lines)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
 }
 
 func TestRecordID(t *testing.T) {
@@ -113,7 +143,7 @@ func TestRecordID(t *testing.T) {
 func BenchmarkNewRecords(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		f := TestFilePhi
-		r := Existing(&f)
+		r := LoadOld(context.Background(), f, helperCorrectHeader(), Identity{}, nil, 0).Records()
 
 		in := helperTestReader(TestFile)
 
@@ -123,7 +153,7 @@ func BenchmarkNewRecords(b *testing.B) {
 		out := make(chan []string, buf)
 		done := make(chan struct{})
 
-		New(r, header, in, out, done)
+		New(context.Background(), r, nil, nil, header, Identity{}, in, out, done)
 
 		for l := range out {
 			_ = l
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// Removed identifies records of an existing output file that are absent from an input stream, e.g. retracted pathology reports.
+// Input records are passed through to an output channel. Once input is exhausted, removed records are sent to a second output channel, in the order of the existing output file, and the corresponding patient identifiers are saved to a file. Identifiers are sorted in deterministic output ordering mode.
+// The existing output file is read again once input is exhausted. Hashes of input records are held in memory, or sorted to temporary files if the diff is external.
+// Records are identified using the identifying columns and normalization of the existing output file records.
+// Removed records are recorded in the run ledger of a state database, if provided.
+func Removed(ctx context.Context, old *Old, state *State, in chan []string, header []string) (out chan []string, removed chan []string, done chan struct{}) {
+	var buf int64 = 1e5
+	out = make(chan []string, buf)
+	removed = make(chan []string, buf)
+	done = make(chan struct{})
+
+	idCol, err := RecordID(header)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	colNames := headerParse(header)
+	idIdx := colNames[idCol]
+
+	go func() {
+		var counter int64
+
+		n := make(map[string](struct{}))
+		w := OutputFile(ctx, "removed-ids", "removed-ids.txt", []string{"identifier"})
+
+		emit := func(l []string) {
+			removed <- l
+
+			atomic.AddInt64(&counter, 1)
+
+			if idIdx >= len(l) || l[idIdx] == "" {
+				return
+			}
+
+			state.Record("removed", l[idIdx], column(l, colNames, "AccessionNumber"))
+
+			n[l[idIdx]] = struct{}{} // do not duplicate person instance output
+		}
+
+		if old.external {
+			err = externalRemoved(ctx, old, in, out, emit)
+		} else {
+			err = removedRecords(old, in, out, emit)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		for _, k := range sortedKeys(ctx, n) {
+			err := w.Write([]string{k})
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		err = w.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		close(removed)
+		close(done)
+
+		log.Println("Removed records:", counter, "Person-instances with removed records:", len(n))
+	}()
+
+	return out, removed, done
+}
+
+// removedRecords passes input records through to an output channel, holding their hashes in memory, then reads the existing output file and calls a function with each existing record absent from the input.
+func removedRecords(old *Old, in chan []string, out chan []string, fn func(l []string)) (err error) {
+	current := make(Store)
+
+	for l := range in {
+		i := l
+		out <- i
+
+		h, err := old.hash(i)
+		if err != nil {
+			return err
+		}
+
+		current[h] = struct{}{}
+	}
+
+	close(out)
+
+	log.Println("comparing file", old.name, "to current records")
+
+	_, err = old.each(func(seq uint64, l []string) error {
+		h, err := old.hash(l)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := current[h]; !ok {
+			fn(l)
+		}
+
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemoved(t *testing.T) {
+	old := TestFileOld
+
+	defer os.Remove("removed-ids.txt")
+
+	// in-memory and external sort diffs, with a small memory budget
+	for name, budget := range map[string]int64{"In memory": 0, "External sort": 512} {
+		budget := budget
+
+		t.Run(name, func(t *testing.T) {
+			in := helperTestReader(TestFile)
+
+			out, removed, done := Removed(context.Background(), LoadOld(context.Background(), old, helperCorrectHeader(), Identity{}, nil, budget), nil, in, helperCorrectHeader())
+
+			var passed int64
+			for range out {
+				passed++
+			}
+
+			var ids []string
+			for l := range removed {
+				ids = append(ids, l[0])
+			}
+
+			<-done
+
+			t.Run("Pass through current records", func(t *testing.T) {
+				diff := cmp.Diff(int64(12), passed)
+				if diff != "" {
+					t.Fatalf(diff)
+				}
+			})
+
+			t.Run("Detect removed records in the order of the old file", func(t *testing.T) {
+				want := []string{"Unchanged1", "1000000004", "1000000006", "Unchanged3", "1000000007"}
+
+				diff := cmp.Diff(want, ids)
+				if diff != "" {
+					t.Fatalf(diff)
+				}
+			})
+
+			t.Run("Detect patients with removed records", func(t *testing.T) {
+				diff := cmp.Diff(int64(6), helperCsvLines("removed-ids.txt"))
+				if diff != "" {
+					t.Fatalf(diff)
+				}
+			})
+		})
+	}
+}
+
+func TestRemovedOldHeader(t *testing.T) {
+	old := "test-removed-old-header.csv"
+
+	err := os.WriteFile(old, []byte("Value,MRN\nb,1000000002\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(old)
+	defer os.Remove("removed-ids.txt")
+
+	in := make(chan []string, 1)
+	in <- []string{"1000000001", "a"}
+	close(in)
+
+	out, removed, done := Removed(context.Background(), LoadOld(context.Background(), old, []string{"MRN", "Value"}, Identity{}, nil, 0), nil, in, []string{"MRN", "Value"})
+
+	for range out {
+	}
+
+	for range removed {
+	}
+
+	<-done
+
+	t.Run("Identify patients of removed records by the columns of the old file", func(t *testing.T) {
+		got, err := os.ReadFile("removed-ids.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff("identifier\n1000000002\n", string(got))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// reviewBucket is the state database bucket mapping unique strings of a category, e.g. "pdl1" or "msi", to their quality assurance review.
+var reviewBucket = []byte("review")
+
+// ReviewHeader is the header of quality assurance review output and of review decision input.
+var ReviewHeader = []string{"category", "string", "status", "canonical", "reviewer", "reviewed", "first-seen-run"}
+
+// ReviewCategories are the categories of unique strings reviewed for quality assurance.
+var ReviewCategories = []string{"pdl1", "msi"}
+
+// review statuses. Strings are pending until a reviewer approves, rejects, or maps them to a canonical value.
+const (
+	Pending  = "pending"
+	Approved = "approved"
+	Rejected = "rejected"
+	Mapped   = "mapped"
+)
+
+// Review is a quality assurance review of a unique string.
+type Review struct {
+	Status    string
+	Canonical string
+	Reviewer  string
+	Reviewed  string
+	Run       string
+}
+
+// reviewKey creates a review key from a category and a unique string.
+func reviewKey(category string, s string) []byte {
+	return []byte(category + "\x00" + s)
+}
+
+// encode encodes a review as a state database value.
+func (r Review) encode() []byte {
+	return []byte(strings.Join([]string{r.Status, r.Canonical, r.Reviewer, r.Reviewed, r.Run}, "\x00"))
+}
+
+// decodeReview decodes a review from a state database value.
+func decodeReview(v []byte) (r Review) {
+	x := strings.SplitN(string(v), "\x00", 5)
+
+	for len(x) < 5 {
+		x = append(x, "")
+	}
+
+	return Review{
+		Status:    x[0],
+		Canonical: x[1],
+		Reviewer:  x[2],
+		Reviewed:  x[3],
+		Run:       x[4],
+	}
+}
+
+// Seen adds a unique string of a category to be saved as pending review when the run is committed, unless it has already been seen. Seen does nothing if the state database is nil.
+func (s *State) Seen(category string, str string) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	s.seen[string(reviewKey(category, str))] = struct{}{}
+	s.Unlock()
+}
+
+// Review gets the quality assurance review of a unique string of a category. The review status is empty if the string has not been seen, or if the state database is nil.
+func (s *State) Review(category string, str string) (r Review, err error) {
+	if s == nil {
+		return r, nil
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(reviewBucket).Get(reviewKey(category, str))
+		if v != nil {
+			r = decodeReview(v)
+		}
+
+		return nil
+	})
+
+	return r, err
+}
+
+// Reviewed checks that a unique string of a category has been reviewed, i.e. approved, rejected, or mapped.
+// Reviewed returns false if the state database is nil.
+func (s *State) Reviewed(category string, str string) (reviewed bool, err error) {
+	r, err := s.Review(category, str)
+
+	return r.Status != "" && r.Status != Pending, err
+}
+
+// commitReviews saves unique strings seen during the run that are not already in the state database as pending review, in a transaction.
+func (s *State) commitReviews(tx *bolt.Tx) (added int64, err error) {
+	pending := Review{Status: Pending, Run: s.run}.encode()
+
+	b := tx.Bucket(reviewBucket)
+
+	for k := range s.seen {
+		if b.Get([]byte(k)) != nil {
+			continue
+		}
+
+		err := b.Put([]byte(k), pending)
+		if err != nil {
+			return added, err
+		}
+
+		added++
+	}
+
+	return added, nil
+}
+
+// Reviews writes the quality assurance reviews of unique strings as CSV.
+// Reviews are optionally limited to a category and to strings pending review.
+func (s *State) Reviews(category string, pendingOnly bool, out io.Writer) (n int64, err error) {
+	w := csv.NewWriter(out)
+
+	err = w.Write(ReviewHeader)
+	if err != nil {
+		return n, err
+	}
+
+	var prefix []byte
+	if category != "" {
+		prefix = reviewKey(category, "")
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reviewBucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			r := decodeReview(v)
+
+			if pendingOnly && r.Status != Pending {
+				continue
+			}
+
+			key := strings.SplitN(string(k), "\x00", 2)
+
+			err := w.Write([]string{key[0], key[1], r.Status, r.Canonical, r.Reviewer, r.Reviewed, r.Run})
+			if err != nil {
+				return err
+			}
+
+			n++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	w.Flush()
+
+	return n, w.Error()
+}
+
+// reviewCategory checks that a category of unique strings is reviewed.
+func reviewCategory(category string) bool {
+	for _, c := range ReviewCategories {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImportReviews imports quality assurance review decisions from CSV with the columns of ReviewHeader, e.g. an edited list of pending strings.
+// Rows that are still pending are skipped. Rows of unknown categories are rejected. A reviewer is required for each decision, either in the row or as a default. The review time is the current time unless given in the row.
+func (s *State) ImportReviews(in io.Reader, reviewer string) (n int64, err error) {
+	r := csv.NewReader(in)
+
+	h, err := r.Read()
+	if err != nil {
+		return n, err
+	}
+
+	colNames := headerParse(h)
+
+	for _, col := range []string{"category", "string", "status"} {
+		if _, ok := colNames[col]; !ok {
+			return n, fmt.Errorf("review decisions are missing column %q", col)
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var decisions [][]string
+
+	for {
+		l, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		decisions = append(decisions, l)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reviewBucket)
+
+		for _, l := range decisions {
+			category := column(l, colNames, "category")
+			str := column(l, colNames, "string")
+
+			if !reviewCategory(category) {
+				return fmt.Errorf("string %q has unknown category %q, expected one of %s", str, category, strings.Join(ReviewCategories, ", "))
+			}
+
+			d := Review{
+				Status:    strings.ToLower(strings.TrimSpace(column(l, colNames, "status"))),
+				Canonical: column(l, colNames, "canonical"),
+				Reviewer:  column(l, colNames, "reviewer"),
+				Reviewed:  column(l, colNames, "reviewed"),
+			}
+
+			switch d.Status {
+			case Pending, "":
+				continue
+			case Approved, Rejected:
+			case Mapped:
+				if d.Canonical == "" {
+					return fmt.Errorf("string %q of category %q is mapped without a canonical value", str, category)
+				}
+			default:
+				return fmt.Errorf("string %q of category %q has unknown review status %q", str, category, d.Status)
+			}
+
+			if d.Reviewer == "" {
+				d.Reviewer = reviewer
+			}
+
+			if d.Reviewer == "" {
+				return fmt.Errorf("string %q of category %q has no reviewer", str, category)
+			}
+
+			if d.Reviewed == "" {
+				d.Reviewed = now
+			}
+
+			k := reviewKey(category, str)
+
+			// preserve the run in which the string was first seen
+			if v := b.Get(k); v != nil {
+				d.Run = decodeReview(v).Run
+			}
+
+			err := b.Put(k, d.encode())
+			if err != nil {
+				return err
+			}
+
+			n++
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// review lists unique strings pending quality assurance review or imports review decisions.
+func review(f reviewFlags, out io.Writer) (err error) {
+	if *f.state == "" {
+		return errors.New("review requires a state database (--state)")
+	}
+
+	if _, err := os.Stat(*f.state); err != nil {
+		return err
+	}
+
+	// listing only reads the state database
+	var s *State
+	if f.action == "list" {
+		s, err = OpenStateReadOnly(*f.state)
+	} else {
+		s, err = OpenState(*f.state, "")
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	switch f.action {
+	case "list":
+		n, err := s.Reviews(*f.category, !*f.all, out)
+		if err != nil {
+			return err
+		}
+
+		log.Println("review:", n, "strings")
+
+	case "import":
+		if len(f.files) == 0 {
+			return errors.New("review import requires a review decisions file")
+		}
+
+		for _, name := range f.files {
+			in, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+
+			n, err := s.ImportReviews(in, *f.reviewer)
+			in.Close()
+			if err != nil {
+				return err
+			}
+
+			log.Println("review: imported", n, "decisions from", name)
+		}
+
+	default:
+		return fmt.Errorf("unknown review command %q, expected 'list' or 'import'", f.action)
+	}
+
+	return nil
+}
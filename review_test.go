@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperReviewDiffUnq identifies unique strings pending review using a state database and returns unique strings and new strings.
+func helperReviewDiffUnq(s *State, l []string) (unq [][]string, unqNew []string) {
+	in := make(chan []string)
+
+	go func() {
+		in <- l
+		close(in)
+	}()
+
+	channels, done := DiffUnq(context.Background(), s, nil, in, "pdl1")
+
+	<-done
+
+	for l := range channels["pdl1-unique-strings"] {
+		unq = append(unq, l)
+	}
+
+	for l := range channels["pdl1-unique-strings-new"] {
+		unqNew = append(unqNew, l[0])
+	}
+
+	return unq, unqNew
+}
+
+func TestReview(t *testing.T) {
+	name := "test-review.db"
+
+	defer os.Remove(name)
+
+	s, err := OpenState(name, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	t.Run("Surface all strings before review", func(t *testing.T) {
+		_, got := helperReviewDiffUnq(s, []string{"A", "B", "C", "A"})
+
+		diff := cmp.Diff([]string{"A", "B", "C"}, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	err = s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("List strings pending review", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		n, err := s.Reviews("pdl1", true, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(3), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff = cmp.Diff([]string{"pdl1", "A", Pending, "", "", "", "run1"}, rows[1])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	decisions := strings.Join([]string{
+		strings.Join(ReviewHeader, ","),
+		"pdl1,A,approved,,,,run1",
+		"pdl1,B,mapped,b,reviewer2,2021-01-01T00:00:00Z,run1",
+		"pdl1,C,pending,,,,run1",
+	}, "\n")
+
+	t.Run("Import review decisions", func(t *testing.T) {
+		n, err := s.ImportReviews(strings.NewReader(decisions), "reviewer1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(int64(2), n)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Record reviewer and review time", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		_, err := s.Reviews("pdl1", false, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff([]string{"pdl1", "B", Mapped, "b", "reviewer2", "2021-01-01T00:00:00Z", "run1"}, rows[2])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		diff = cmp.Diff("reviewer1", rows[1][4])
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Surface only unreviewed strings after review", func(t *testing.T) {
+		_, got := helperReviewDiffUnq(s, []string{"A", "B", "C", "D"})
+
+		diff := cmp.Diff([]string{"C", "D"}, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Output canonical values of mapped strings", func(t *testing.T) {
+		got, _ := helperReviewDiffUnq(s, []string{"A", "B"})
+
+		diff := cmp.Diff([][]string{{"A", ""}, {"B", "b"}}, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	invalid := map[string]struct {
+		input    string
+		reviewer string
+	}{
+		"Reject unknown review status":           {input: "pdl1,C,maybe,,,,", reviewer: "reviewer1"},
+		"Reject mapping without canonical value": {input: "pdl1,C,mapped,,,,", reviewer: "reviewer1"},
+		"Reject decision without reviewer":       {input: "pdl1,C,approved,,,,", reviewer: ""},
+		"Reject unknown category":                {input: "test-review,C,approved,,,,", reviewer: "reviewer1"},
+	}
+
+	for name, tc := range invalid {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			in := strings.NewReader(strings.Join(ReviewHeader, ",") + "\n" + tc.input)
+
+			_, err := s.ImportReviews(in, tc.reviewer)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunsDir is the name of the directory of run directories in the output directory.
+const RunsDir = "runs"
+
+// LatestFile is the name of the file pointing to the latest successful run directory.
+const LatestFile = "latest"
+
+// runLayout is the time layout of run identifiers, used to name run directories and to recognise them.
+const runLayout = "20060102T150405.000Z"
+
+// runID creates a run identifier from the current time.
+func runID() string {
+	return time.Now().UTC().Format(runLayout)
+}
+
+// Runs is a directory of timestamped run directories, each containing the outputs of a run, and a pointer to the latest successful run.
+type Runs struct {
+	dir string
+}
+
+// OpenRuns opens or creates a directory of run directories, readable by the owner only.
+func OpenRuns(dir string) (r *Runs, err error) {
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runs{dir: dir}, nil
+}
+
+// Dir gets the directory of a run.
+func (r *Runs) Dir(run string) string {
+	return filepath.Join(r.dir, run)
+}
+
+// Latest gets the latest successful run, or an empty string if there is none.
+func (r *Runs) Latest() (run string, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, LatestFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	run = strings.TrimSpace(string(b))
+
+	_, err = time.Parse(runLayout, run)
+	if err != nil {
+		return "", errors.New(strings.Join([]string{"invalid latest run", run, "in", filepath.Join(r.dir, LatestFile)}, " "))
+	}
+
+	return run, nil
+}
+
+// SetLatest points to the latest successful run. The pointer file is replaced atomically.
+func (r *Runs) SetLatest(run string) (err error) {
+	name := filepath.Join(r.dir, LatestFile)
+
+	f, err := tempFile(name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(run + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// runs lists run directories in chronological order.
+func (r *Runs) runs() (runs []string, err error) {
+	entries, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		_, err := time.Parse(runLayout, e.Name())
+		if err != nil {
+			continue
+		}
+
+		runs = append(runs, e.Name())
+	}
+
+	sort.Strings(runs)
+
+	return runs, nil
+}
+
+// Prune removes run directories, keeping the latest successful runs and the current run.
+// Directories of runs that did not succeed, without a manifest, are removed as well. Nothing is removed if keep is not positive.
+func (r *Runs) Prune(keep int, current string) (removed []string, err error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+
+	latest, err := r.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := r.runs()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := 0
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+
+		if run == current || run == latest {
+			kept++
+			continue
+		}
+
+		_, err := os.Stat(filepath.Join(r.Dir(run), ManifestFile))
+		if err == nil && kept < keep {
+			kept++
+			continue
+		}
+
+		err = os.RemoveAll(r.Dir(run))
+		if err != nil {
+			return removed, err
+		}
+
+		log.Println("pruned run directory", r.Dir(run))
+
+		removed = append(removed, run)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperRunDir creates a run directory, with a manifest if the run succeeded.
+func helperRunDir(t *testing.T, r *Runs, run string, success bool) {
+	err := os.MkdirAll(r.Dir(run), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !success {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(r.Dir(run), ManifestFile), []byte("{}\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := OpenRuns(filepath.Join(dir, RunsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("No latest run", func(t *testing.T) {
+		latest, err := r.Latest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff("", latest)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	runs := []struct {
+		run     string
+		success bool
+	}{
+		{"20210101T000000.000Z", true},
+		{"20210102T000000.000Z", true},
+		{"20210103T000000.000Z", false},
+		{"20210104T000000.000Z", true},
+		{"20210105T000000.000Z", true},
+	}
+
+	for _, tc := range runs {
+		helperRunDir(t, r, tc.run, tc.success)
+	}
+
+	err = r.SetLatest("20210105T000000.000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Point to the latest run", func(t *testing.T) {
+		latest, err := r.Latest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff("20210105T000000.000Z", latest)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Keep all runs by default", func(t *testing.T) {
+		removed, err := r.Prune(0, "20210106T000000.000Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(0, len(removed))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Prune old and failed runs", func(t *testing.T) {
+		removed, err := r.Prune(2, "20210105T000000.000Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff([]string{"20210103T000000.000Z", "20210102T000000.000Z", "20210101T000000.000Z"}, removed)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		kept, err := r.runs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff = cmp.Diff([]string{"20210104T000000.000Z", "20210105T000000.000Z"}, kept)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Recognise run identifiers as runs", func(t *testing.T) {
+		run := runID()
+		helperRunDir(t, r, run, true)
+
+		err := r.SetLatest(run)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		latest, err := r.Latest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(run, latest)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestFullRuns(t *testing.T) {
+	dir := "test-runs"
+
+	defer os.RemoveAll(dir)
+
+	runs := true
+	keepRuns := 1
+
+	f := helperFlags()
+	f.outputDir = &dir
+	f.runs = &runs
+	f.keepRuns = &keepRuns
+
+	innerTest(f, TestFileOld)
+	innerTest(f, TestFile)
+
+	r := &Runs{dir: filepath.Join(dir, RunsDir)}
+
+	latest, err := r.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Diff against the results of the previous run by default", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(r.Dir(latest), "results-removed.csv")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Prune the previous run", func(t *testing.T) {
+		kept, err := r.runs()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff([]string{latest}, kept)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
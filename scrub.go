@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Scrub configures the PHI scrubber of unique strings: built-in detectors and additional patterns by placeholder type.
+type Scrub struct {
+	Detectors []string          `yaml:"detectors"` // built-in detectors to use (default all)
+	Patterns  map[string]string `yaml:"patterns"`  // additional regular expressions by placeholder type
+}
+
+// detector is a pattern detector that replaces matches with a typed placeholder.
+type detector struct {
+	placeholder string
+	pat         *regexp.Regexp
+}
+
+// ScrubDetectors are the built-in PHI pattern detectors, in the order they are applied.
+var ScrubDetectors = []string{"phone", "date", "accession", "mrn"}
+
+// scrubPatterns are the regular expressions of the built-in PHI pattern detectors.
+var scrubPatterns = map[string]string{
+	"phone":     `\(?\b\d{3}\)?[-. ]?\d{3}[-.]\d{4}\b`,
+	"date":      `(?i)\b\d{1,2}[/-]\d{1,2}[/-]\d{2,4}\b|\b\d{4}-\d{2}-\d{2}\b|\b(?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.? \d{1,2},? \d{4}\b`,
+	"accession": `\b[A-Z]{1,4}-?\d{2}-\d{3,}\b|\b\d{11,}\b`,
+	"mrn":       `\b\d{6,10}\b`,
+}
+
+// minNameLength is the minimum length of a PatientName token used to detect names. Shorter tokens, e.g. initials, are not used.
+const minNameLength = 3
+
+// Scrubber replaces PHI in free-text strings with typed placeholders, e.g. [NAME] or [DATE].
+// Names are detected using a list of PatientName tokens collected from the input data of the run.
+type Scrubber struct {
+	detectors []detector
+	names     map[string](struct{})
+	sync.Mutex
+}
+
+// placeholder creates the placeholder of a type of PHI.
+func placeholder(name string) string {
+	return "[" + strings.ToUpper(name) + "]"
+}
+
+// NewScrubber creates a Scrubber from a scrubber configuration.
+func NewScrubber(conf Scrub) (s *Scrubber, err error) {
+	s = &Scrubber{names: make(map[string](struct{}))}
+
+	detectors := conf.Detectors
+	if len(detectors) == 0 {
+		detectors = ScrubDetectors
+	}
+
+	for _, name := range detectors {
+		p, ok := scrubPatterns[name]
+		if !ok {
+			return nil, errors.New(strings.Join([]string{"unknown scrub detector", name}, " "))
+		}
+
+		s.detectors = append(s.detectors, detector{placeholder(name), regexp.MustCompile(p)})
+	}
+
+	// additional patterns in a stable order
+	var types []string
+	for name := range conf.Patterns {
+		types = append(types, name)
+	}
+
+	sort.Strings(types)
+
+	for _, name := range types {
+		pat, err := regexp.Compile(conf.Patterns[name])
+		if err != nil {
+			return nil, err
+		}
+
+		s.detectors = append(s.detectors, detector{placeholder(name), pat})
+	}
+
+	return s, nil
+}
+
+// nameTokens matches the tokens of a name.
+var nameTokens = regexp.MustCompile(`[\p{L}'-]+`)
+
+// nameParts matches the parts of a hyphenated or apostrophized name token.
+var nameParts = regexp.MustCompile(`\p{L}+`)
+
+// AddName adds the tokens of a patient name to the name list.
+func (s *Scrubber) AddName(name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, t := range nameTokens.FindAllString(name, -1) {
+		t = strings.Trim(t, "'-")
+		if len(t) < minNameLength {
+			continue
+		}
+
+		s.names[strings.ToLower(t)] = struct{}{}
+	}
+}
+
+// Names collects the patient names of an input stream to the name list. Input records are passed through to an output channel.
+func (s *Scrubber) Names(in chan []string, header []string) (out chan []string) {
+	var buf int64 = 1e5
+	out = make(chan []string, buf)
+
+	i, ok := headerParse(header)["PatientName"]
+
+	go func() {
+		for l := range in {
+			if ok && i < len(l) {
+				s.AddName(l[i])
+			}
+
+			out <- l
+		}
+
+		close(out)
+	}()
+
+	return out
+}
+
+// scrubName replaces a token of a string with a placeholder if it is a name of the name list. Parts of hyphenated or apostrophized tokens, e.g. "Smith-Jones" or "O'Brien", are replaced if the whole token is not a name. The name list must be locked.
+func (s *Scrubber) scrubName(t string) string {
+	n := strings.Trim(t, "'-")
+
+	if _, ok := s.names[strings.ToLower(n)]; ok {
+		return strings.Replace(t, n, placeholder("name"), 1)
+	}
+
+	if !strings.ContainsAny(n, "'-") {
+		return t
+	}
+
+	return nameParts.ReplaceAllStringFunc(t, func(p string) string {
+		if _, ok := s.names[strings.ToLower(p)]; ok {
+			return placeholder("name")
+		}
+
+		return p
+	})
+}
+
+// Scrub replaces names and pattern detector matches in a string with typed placeholders.
+// Names are matched by token, ignoring case, so that a name matches whole words only.
+func (s *Scrubber) Scrub(str string) string {
+	out := str
+
+	s.Lock()
+	if len(s.names) > 0 {
+		out = nameTokens.ReplaceAllStringFunc(out, s.scrubName)
+	}
+	s.Unlock()
+
+	for _, d := range s.detectors {
+		out = d.pat.ReplaceAllString(out, d.placeholder)
+	}
+
+	return out
+}
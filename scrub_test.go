@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScrub(t *testing.T) {
+	s, err := NewScrubber(Scrub{Patterns: map[string]string{"ssn": `\b\d{3}-\d{2}-\d{4}\b`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.AddName("DOE, JANE Q")
+
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"Scrub names":                     {input: "Discussed with Dr. Doe and jane.", want: "Discussed with Dr. [NAME] and [NAME]."},
+		"Do not scrub initials":           {input: "Patient Q tumor proportion score: 10%", want: "Patient Q tumor proportion score: 10%"},
+		"Scrub MRNs":                      {input: "MRN 1000000001 reviewed", want: "MRN [MRN] reviewed"},
+		"Scrub accession numbers":         {input: "See S20-12345 and 00000111111111", want: "See [ACCESSION] and [ACCESSION]"},
+		"Scrub dates":                     {input: "Received 11/15/2020, signed 2020-11-16 and Nov 17, 2020", want: "Received [DATE], signed [DATE] and [DATE]"},
+		"Scrub phone numbers":             {input: "Call (555) 123-4567 or 555-123-4567", want: "Call [PHONE] or [PHONE]"},
+		"Scrub configured patterns":       {input: "SSN 123-45-6789", want: "SSN [SSN]"},
+		"Keep strings without PHI":        {input: "Tumor proportion score: 10%", want: "Tumor proportion score: 10%"},
+		"Keep scores with few digits":     {input: "combined positive score (cps): 15", want: "combined positive score (cps): 15"},
+		"Keep words containing a name":    {input: "Doesn't match", want: "Doesn't match"},
+		"Scrub names case-insensitively":  {input: "JANE DOE", want: "[NAME] [NAME]"},
+		"Keep accented words":             {input: "Janeé", want: "Janeé"},
+		"Scrub parts of hyphenated names": {input: "Smith-Doe's report", want: "Smith-[NAME]'s report"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, s.Scrub(tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Scrub names of a large name list", func(t *testing.T) {
+		s, err := NewScrubber(Scrub{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// more names than fit in a regular expression
+		for i := 0; i < 200000; i++ {
+			var b strings.Builder
+			for n := i; b.Len() < 4; n /= 26 {
+				b.WriteRune(rune('a' + n%26))
+			}
+
+			s.AddName(b.String())
+		}
+
+		diff := cmp.Diff("Seen by [NAME]", s.Scrub("Seen by Abcd"))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Reject unknown detectors", func(t *testing.T) {
+		_, err := NewScrubber(Scrub{Detectors: []string{"ssn"}})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestDiffUnqScrub(t *testing.T) {
+	s, err := NewScrubber(Scrub{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []string{"PatientName", "Value"}
+
+	rows := make(chan []string, 2)
+	rows <- []string{"DOE, JANE", ""}
+	rows <- []string{"ROE, RICHARD", ""}
+	close(rows)
+
+	// names are collected from the whole run before strings are scrubbed
+	for range s.Names(rows, header) {
+	}
+
+	in := make(chan []string, 1)
+	in <- []string{"Reviewed by Doe", "Reviewed by Roe", "No PHI"}
+	close(in)
+
+	channels, done := DiffUnq(context.Background(), nil, s, in, "test-scrub")
+
+	<-done
+
+	var got []string
+	for l := range channels["test-scrub-unique-strings"] {
+		got = append(got, l...)
+	}
+
+	for range channels["test-scrub-unique-strings-new"] {
+	}
+
+	diff := cmp.Diff([]string{"Reviewed by [NAME]", "No PHI"}, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Sink is a destination of output rows: a file, a table of the SQLite database output, standard output, or memory.
+// A Sink is opened with a header, written row by row, and closed. Closing a Sink completes the output, or discards it if the run is cancelled.
+type Sink interface {
+	Open(h []string) error
+	Write(l []string) error
+	Close() error
+}
+
+// checksum is a running checksum of written bytes.
+type checksum interface {
+	io.Writer
+	Sum(b []byte) []byte
+}
+
+// StdoutKind is the sink kind of an output category streamed to standard output.
+const StdoutKind = "stdout"
+
+// SinkKinds are the sink kinds of output categories: a file of an output format, a table of the SQLite database output, or standard output.
+var SinkKinds = []string{CSV, JSONL, Parquet, SQLite, StdoutKind}
+
+// checkSinks checks that the sink kinds of output categories are supported, and that at most one category is streamed to standard output.
+// Outputs read back by the next run to diff against must be CSV files, except results if a state database is used, since other sinks cannot be read back. Results are written in the output format unless they have a sink. The pseudonym crosswalk must be written to a file.
+func checkSinks(sinks map[string]string, format string, state bool) (err error) {
+	var stdout []string
+
+	for category, kind := range sinks {
+		err := checkCategory(category, append(append([]string{}, Categories...), FileCategories...))
+		if err != nil {
+			return err
+		}
+
+		ok := false
+
+		for _, k := range SinkKinds {
+			if k == kind {
+				ok = true
+			}
+		}
+
+		if !ok {
+			return errors.New(strings.Join([]string{"unknown sink", kind, "of output", category, "expected one of", strings.Join(SinkKinds, ", ")}, " "))
+		}
+
+		if kind == StdoutKind {
+			stdout = append(stdout, category)
+		}
+
+		if readBack(category) && kind != CSV && !(category == "results" && state) {
+			return errors.New(strings.Join([]string{"output", category, "must be written to csv to be diffed by the next run, not", kind}, " "))
+		}
+
+		if category == "pseudonyms" && (kind == SQLite || kind == StdoutKind) {
+			return errors.New(strings.Join([]string{"output pseudonyms must be written to a file readable by the owner only, not", kind}, " "))
+		}
+	}
+
+	if _, ok := sinks["results"]; !ok && format != CSV && !state {
+		return errors.New(strings.Join([]string{"output results must be written to csv to be diffed by the next run, not", format, "use --state or a csv sink of results"}, " "))
+	}
+
+	if len(stdout) > 1 {
+		sort.Strings(stdout)
+
+		return errors.New(strings.Join([]string{"outputs", strings.Join(stdout, ", "), "are all streamed to stdout"}, " "))
+	}
+
+	return nil
+}
+
+// hasSink reports whether any output category has a sink kind.
+func hasSink(sinks map[string]string, kind string) bool {
+	for _, k := range sinks {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stdoutCategory gets the output category streamed to standard output, given by command line flag or by sink kind, or an empty string if there is none.
+func stdoutCategory(sinks map[string]string, flag string) (category string, err error) {
+	for c, kind := range sinks {
+		if kind == StdoutKind {
+			category = c
+		}
+	}
+
+	if category != "" && flag != "" && category != flag {
+		return "", errors.New(strings.Join([]string{"--stdout", flag, "conflicts with output", category, "streamed to stdout in the configuration file"}, " "))
+	}
+
+	if flag != "" {
+		category = flag
+	}
+
+	return category, nil
+}
+
+// sinksKey is the context key of the sink kinds of output categories.
+type sinksKey struct{}
+
+// withSinks returns a context carrying the sink kinds of output categories.
+func withSinks(ctx context.Context, sinks map[string]string) context.Context {
+	return context.WithValue(ctx, sinksKey{}, sinks)
+}
+
+// sinkKind gets the sink kind of an output category from a context, the output format of the context by default.
+func sinkKind(ctx context.Context, category string) string {
+	sinks, _ := ctx.Value(sinksKey{}).(map[string]string)
+
+	kind, ok := sinks[category]
+	if !ok {
+		return outputFormatFrom(ctx)
+	}
+
+	return kind
+}
+
+// memorySinksKey is the context key of the memory sinks of output categories.
+type memorySinksKey struct{}
+
+// withMemorySinks returns a context carrying memory sinks of output categories, which replace any other sink of the categories, e.g. to test outputs without files.
+func withMemorySinks(ctx context.Context, sinks map[string]*MemorySink) context.Context {
+	return context.WithValue(ctx, memorySinksKey{}, sinks)
+}
+
+// memorySinkFrom gets the memory sink of an output category from a context, or nil if there is none.
+func memorySinkFrom(ctx context.Context, category string) *MemorySink {
+	sinks, _ := ctx.Value(memorySinksKey{}).(map[string]*MemorySink)
+
+	return sinks[category]
+}
+
+// NewSink creates the sink of an output category, chosen by the memory sinks, sink kinds, and output format of the context.
+// The category streamed to standard output is streamed in its output format, or as CSV if it has none. Categories written to files are named after the category with the extension of the output format, e.g. results.csv.
+func NewSink(ctx context.Context, category string) Sink {
+	if m := memorySinkFrom(ctx, category); m != nil {
+		return m
+	}
+
+	kind := sinkKind(ctx, category)
+
+	if s := stdoutFrom(ctx); s.match(category) {
+		if kind == StdoutKind {
+			kind = outputFormatFrom(ctx)
+		}
+
+		if kind == SQLite {
+			kind = CSV
+		}
+
+		return s.Sink(ctx, category+"."+kind, kind)
+	}
+
+	switch kind {
+	case SQLite:
+		return sqliteFrom(ctx).Sink(ctx, category)
+	default:
+		return NewFileSink(ctx, category+"."+kind, kind, 0o600)
+	}
+}
+
+// FileSink writes rows to an output file of an output format in the output directory of the run, readable by the owner only by default.
+// Rows are written to a temporary file. If the context is cancelled before the FileSink is closed, the temporary file is removed.
+// Otherwise, the row count and SHA-256 checksum of the file are added to the run manifest, if any, and the temporary file is renamed to the output file when the run is committed.
+// If outputs are compressed or encrypted, the file is compressed and then encrypted as it is written. With the SQLite output format, rows are written to a table of the SQLite database output as well.
+type FileSink struct {
+	ctx     context.Context
+	name    string
+	table   string
+	perm    os.FileMode
+	format  string
+	f       *os.File
+	enc     io.WriteCloser
+	comp    io.WriteCloser
+	sum     checksum
+	rows    rowWriter
+	counter int64
+}
+
+// NewFileSink creates a sink of an output file of an output format with given permissions.
+// If outputs are compressed, CSV and JSON Lines files are compressed as they are written and named with the extension of the compression algorithm, e.g. results.csv.gz.
+func NewFileSink(ctx context.Context, name string, format string, perm os.FileMode) *FileSink {
+	table := name
+
+	if compressible(format) {
+		name += CompressedExtensions[compressionFrom(ctx)]
+	}
+
+	return &FileSink{
+		ctx:    ctx,
+		name:   outputsFrom(ctx).Path(cryptFrom(ctx).Name(name)),
+		table:  table,
+		perm:   perm,
+		format: format,
+	}
+}
+
+// Name gets the name of the output file.
+func (s *FileSink) Name() string {
+	return s.name
+}
+
+// Open creates the temporary file and writes the header.
+func (s *FileSink) Open(h []string) (err error) {
+	s.f, err = tempFile(s.name)
+	if err != nil {
+		return err
+	}
+
+	err = s.f.Chmod(s.perm)
+	if err != nil {
+		return err
+	}
+
+	s.sum = sha256.New()
+
+	s.enc, err = cryptFrom(s.ctx).Encrypt(io.MultiWriter(s.f, s.sum))
+	if err != nil {
+		return err
+	}
+
+	c := ""
+	if compressible(s.format) {
+		c = compressionFrom(s.ctx)
+	}
+
+	s.comp, err = compress(s.enc, c)
+	if err != nil {
+		return err
+	}
+
+	s.rows, err = newRows(s.comp, h, s.format)
+	if err != nil {
+		return err
+	}
+
+	if db := sqliteFrom(s.ctx); db != nil && db.all {
+		s.rows = teeRows{s.rows, db.table(s.table, h)}
+	}
+
+	return nil
+}
+
+// Write writes a row.
+func (s *FileSink) Write(l []string) (err error) {
+	atomic.AddInt64(&s.counter, 1)
+
+	return s.rows.Write(l)
+}
+
+// Close completes the file, or removes it if the run is cancelled.
+func (s *FileSink) Close() (err error) {
+	err = s.rows.Close()
+	if err != nil {
+		s.f.Close()
+		return errors.New(s.name + ": " + err.Error())
+	}
+
+	err = s.comp.Close()
+	if err != nil {
+		s.f.Close()
+		return err
+	}
+
+	err = s.enc.Close()
+	if err != nil {
+		s.f.Close()
+		return err
+	}
+
+	err = s.f.Close()
+	if err != nil {
+		return err
+	}
+
+	if s.ctx.Err() != nil {
+		log.Println("run cancelled, discarding", s.name)
+
+		return os.Remove(s.f.Name())
+	}
+
+	manifestFrom(s.ctx).AddOutput(outputsFrom(s.ctx).Rel(s.name), atomic.LoadInt64(&s.counter), s.sum.Sum(nil))
+
+	return outputsFrom(s.ctx).add(s.f.Name(), s.name)
+}
+
+// OutputFile creates and opens the sink of an output category written to a CSV file by default, whatever the output format, e.g. new-ids.txt.
+// If the context has a memory sink, a sink kind other than CSV, or standard output for the category, the sink is created like NewSink instead, and files are named after the category, e.g. new-ids.jsonl.
+func OutputFile(ctx context.Context, category string, name string, h []string) Sink {
+	sinks, _ := ctx.Value(sinksKey{}).(map[string]string)
+
+	kind, ok := sinks[category]
+	if (!ok || kind == CSV) && memorySinkFrom(ctx, category) == nil && !stdoutFrom(ctx).match(category) {
+		return File(ctx, name, h)
+	}
+
+	s := NewSink(withOutputFormat(ctx, CSV), category)
+
+	err := s.Open(h)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return s
+}
+
+// File creates and opens a sink of an output CSV file, readable by the owner only. The sinks of the context are not used; see OutputFile.
+func File(ctx context.Context, name string, h []string) *FileSink {
+	return FilePerm(ctx, name, h, 0o600)
+}
+
+// FilePerm creates and opens a sink of an output CSV file with given permissions, like File.
+func FilePerm(ctx context.Context, name string, h []string, perm os.FileMode) *FileSink {
+	s := NewFileSink(ctx, name, CSV, perm)
+
+	err := s.Open(h)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return s
+}
+
+// MemorySink keeps the header and rows of an output in memory, e.g. to test outputs without files.
+type MemorySink struct {
+	Header []string
+	Rows   [][]string
+	Closed bool
+	sync.Mutex
+}
+
+// Open keeps the header.
+func (s *MemorySink) Open(h []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Header = append([]string{}, h...)
+
+	return nil
+}
+
+// Write keeps a copy of a row.
+func (s *MemorySink) Write(l []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Closed {
+		return errors.New("write to closed sink")
+	}
+
+	s.Rows = append(s.Rows, append([]string{}, l...))
+
+	return nil
+}
+
+// Close marks the sink as closed.
+func (s *MemorySink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Closed = true
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckSinks(t *testing.T) {
+	tests := map[string]struct {
+		input  map[string]string
+		format string
+		state  bool
+		ok     bool
+	}{
+		"Accept output formats":                        {input: map[string]string{"results": CSV, "pdl1": JSONL, "wbc": SQLite, "msi": Parquet}, ok: true},
+		"Accept one stdout category":                   {input: map[string]string{"pdl1": StdoutKind}, ok: true},
+		"Accept sinks of identifier lists":             {input: map[string]string{"new-ids": JSONL, "removed-ids": SQLite, "pseudonyms": Parquet}, ok: true},
+		"Reject unknown sinks":                         {input: map[string]string{"wbc": "xlsx"}, ok: false},
+		"Reject unknown outputs":                       {input: map[string]string{"wbcs": JSONL}, ok: false},
+		"Reject several stdout categories":             {input: map[string]string{"pdl1": StdoutKind, "msi": StdoutKind}, ok: false},
+		"Reject results that cannot be diffed":         {input: map[string]string{"results": Parquet}, ok: false},
+		"Reject results streamed to stdout":            {input: map[string]string{"results": StdoutKind}, ok: false},
+		"Accept results of other formats with state":   {input: map[string]string{"results": JSONL}, state: true, ok: true},
+		"Reject unique strings that cannot be diffed":  {input: map[string]string{"pdl1-unique-strings": JSONL}, state: true, ok: false},
+		"Reject pseudonyms in the SQLite database":     {input: map[string]string{"pseudonyms": SQLite}, ok: false},
+		"Reject pseudonyms streamed to stdout":         {input: map[string]string{"pseudonyms": StdoutKind}, ok: false},
+		"Reject output format that cannot be diffed":   {format: Parquet, ok: false},
+		"Accept other output formats with state":       {format: SQLite, state: true, ok: true},
+		"Accept other output formats with CSV results": {input: map[string]string{"results": CSV}, format: JSONL, ok: true},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			format := tc.format
+			if format == "" {
+				format = CSV
+			}
+
+			err := checkSinks(tc.input, format, tc.state)
+
+			diff := cmp.Diff(tc.ok, err == nil)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestStdoutCategory(t *testing.T) {
+	tests := map[string]struct {
+		sinks map[string]string
+		flag  string
+		want  string
+		ok    bool
+	}{
+		"Stream no category by default":    {sinks: nil, flag: "", want: "", ok: true},
+		"Stream category of flag":          {sinks: nil, flag: "pdl1", want: "pdl1", ok: true},
+		"Stream category of sink":          {sinks: map[string]string{"pdl1": StdoutKind}, flag: "", want: "pdl1", ok: true},
+		"Accept flag and sink of category": {sinks: map[string]string{"pdl1": StdoutKind}, flag: "pdl1", want: "pdl1", ok: true},
+		"Reject conflicting flag and sink": {sinks: map[string]string{"pdl1": StdoutKind}, flag: "msi", want: "", ok: false},
+		"Ignore sinks of other kinds":      {sinks: map[string]string{"pdl1": JSONL}, flag: "", want: "", ok: true},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			got, err := stdoutCategory(tc.sinks, tc.flag)
+
+			diff := cmp.Diff([]interface{}{tc.want, tc.ok}, []interface{}{got, err == nil})
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withOutputs(context.Background(), o)
+	ctx = withOutputFormat(ctx, JSONL)
+	ctx = withSinks(ctx, map[string]string{"results": Parquet, "wbc": SQLite, "pdl1": StdoutKind})
+	ctx = withStdout(ctx, NewStdout("pdl1", ioutil.Discard))
+	ctx = withSQLite(ctx, &SQLiteOutput{})
+
+	tests := map[string]struct {
+		input string
+		want  string
+		name  string
+	}{
+		"Write category to file of sink format":   {input: "results", want: "*main.FileSink", name: o.Path("results.parquet")},
+		"Write category to file of output format": {input: "msi", want: "*main.FileSink", name: o.Path("msi.jsonl")},
+		"Write category to SQLite table":          {input: "wbc", want: "*main.TableSink"},
+		"Stream category to stdout":               {input: "pdl1", want: "*main.StdoutSink", name: "stdout:pdl1.jsonl"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			s := NewSink(ctx, tc.input)
+
+			got := []string{reflect.TypeOf(s).String(), tc.name}
+
+			switch s := s.(type) {
+			case *FileSink:
+				got[1] = s.Name()
+			case *StdoutSink:
+				got[1] = s.name
+			}
+
+			diff := cmp.Diff([]string{tc.want, tc.name}, got)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestMemorySink(t *testing.T) {
+	in := make(chan []string, 2)
+	in <- []string{"1000000001", "Negative"}
+	in <- []string{"1000000002", "10%"}
+	close(in)
+
+	s := &MemorySink{}
+	done := make(chan struct{}, 1)
+
+	WriteRows(context.Background(), in, s, []string{"MRN", "Value"}, done)
+	<-done
+
+	t.Run("Keep header and rows in memory", func(t *testing.T) {
+		diff := cmp.Diff([][]string{{"MRN", "Value"}, {"1000000001", "Negative"}, {"1000000002", "10%"}}, append([][]string{s.Header}, s.Rows...))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Close sink when input is done", func(t *testing.T) {
+		if !s.Closed {
+			t.Fatal("sink not closed")
+		}
+
+		if s.Write([]string{"1000000003"}) == nil {
+			t.Fatal("no error writing to closed sink")
+		}
+	})
+}
+
+func TestWriteMemory(t *testing.T) {
+	header := []string{"MRN", "Value"}
+
+	sinks := map[string]*MemorySink{"results": {}, "new-ids": {}}
+	ctx := withMemorySinks(context.Background(), sinks)
+
+	in := make(chan []string, 1)
+	in <- []string{"1000000001", "Negative"}
+	close(in)
+
+	<-Write(ctx, header, map[string](chan []string){"results": in})
+
+	w := OutputFile(ctx, "new-ids", "new-ids.txt", []string{"identifier"})
+
+	err := w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Write categories to memory sinks", func(t *testing.T) {
+		got := [][][]string{
+			append([][]string{sinks["results"].Header}, sinks["results"].Rows...),
+			append([][]string{sinks["new-ids"].Header}, sinks["new-ids"].Rows...),
+		}
+		want := [][][]string{
+			{{"MRN", "Value"}, {"1000000001", "Negative"}},
+			{{"identifier"}, {"1000000001"}},
+		}
+
+		diff := cmp.Diff(want, got)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write no files", func(t *testing.T) {
+		for _, name := range []string{"results.csv", "new-ids.txt"} {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Fatal(name, "written", err)
+			}
+		}
+	})
+}
+
+func TestOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withOutputs(context.Background(), o)
+	ctx = withOutputFormat(ctx, Parquet)
+	ctx = withSinks(ctx, map[string]string{"new-ids": JSONL, "removed-ids": CSV})
+
+	tests := map[string]struct {
+		category string
+		name     string
+		want     string
+	}{
+		"Write category to CSV file by default": {category: "new-ids-summary", name: "new-ids.csv", want: o.Path("new-ids.csv")},
+		"Write category to CSV file of sink":    {category: "removed-ids", name: "removed-ids.txt", want: o.Path("removed-ids.txt")},
+		"Write category to file of sink format": {category: "new-ids", name: "new-ids.txt", want: o.Path("new-ids.jsonl")},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			s := OutputFile(ctx, tc.category, tc.name, []string{"identifier"})
+
+			err := s.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diff := cmp.Diff(tc.want, s.(*FileSink).Name())
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFullSinks(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	config := "test-sinks.yml"
+
+	defer os.Remove(config)
+	defer os.Remove("wbc.jsonl")
+
+	err := ioutil.WriteFile(config, []byte("sinks:\n  wbc: jsonl\n  pdl1: sqlite\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := helperFlags()
+	f.config = &config
+
+	innerTest(f, TestFile)
+
+	t.Run("Write category to file of sink format", func(t *testing.T) {
+		if _, err := os.Stat("wbc.jsonl"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Write category to SQLite table only", func(t *testing.T) {
+		j, err := ioutil.ReadFile(ManifestFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Manifest
+
+		err = json.Unmarshal(j, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := strconv.FormatInt(m.Outputs[SQLiteFile+"#pdl1"].Rows, 10)
+
+		diff := cmp.Diff([]string{want}, helperSQLiteQuery(t, SQLiteFile, "SELECT count(*) FROM pdl1"))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		tables := helperSQLiteQuery(t, SQLiteFile, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+
+		diff = cmp.Diff([]string{"metadata", "pdl1"}, tables)
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write other categories to CSV files", func(t *testing.T) {
+		for _, name := range []string{"results.csv", "msi.csv"} {
+			if _, err := os.Stat(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := os.Stat("pdl1.csv"); !os.IsNotExist(err) {
+			t.Fatal("pdl1.csv written", err)
+		}
+	})
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver
+)
+
+// SQLiteFile is the name of the SQLite database output file.
+const SQLiteFile = "ih-abstract.sqlite"
+
+// MetadataTable is the name of the SQLite database table holding the run manifest.
+const MetadataTable = "metadata"
+
+// SQLiteIndexes are the columns indexed in SQLite database tables, if present.
+var SQLiteIndexes = []string{"MRN", "AccessionNumber", "ResultDate"}
+
+// sqliteBatch is the number of rows inserted per SQLite database transaction.
+const sqliteBatch = 10000
+
+// sqliteTable is a table of the SQLite database output.
+type sqliteTable struct {
+	name   string
+	header []string
+	stmt   *sql.Stmt
+}
+
+// sqliteOp is an operation on the SQLite database output: creating a table, or inserting a row into a table.
+type sqliteOp struct {
+	t   *sqliteTable
+	row []string
+}
+
+// SQLiteOutput is a SQLite database output file with a table for each output.
+// Tables are created and rows are inserted by a single goroutine, in batched transactions, so that writers never wait for each other.
+// The database is built in a temporary file in the output directory, readable by the owner only, and added to the outputs of the run when closed. The database cannot be encrypted, so it is not an output of runs with encrypted outputs.
+type SQLiteOutput struct {
+	name   string
+	tmp    string
+	db     *sql.DB
+	ops    chan sqliteOp
+	done   chan struct{}
+	rows   int64
+	all    bool
+	staged bool
+
+	tables []*sqliteTable
+}
+
+// NewSQLiteOutput creates the SQLite database output of a run in the output directory.
+// If all is set, all output files are written to tables as well, except those written with a context without the SQLite database output.
+func NewSQLiteOutput(ctx context.Context, all bool) (s *SQLiteOutput, err error) {
+	name := outputsFrom(ctx).Path(SQLiteFile)
+
+	f, err := tempFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := f.Name()
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	// rows are inserted by a single goroutine
+	db.SetMaxOpenConns(1)
+
+	// the database is a temporary file until the run is committed, so it need not survive a crash
+
+	for _, pragma := range []string{"PRAGMA journal_mode = OFF", "PRAGMA synchronous = OFF"} {
+		_, err := db.Exec(pragma)
+		if err != nil {
+			db.Close()
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+
+	var buf int64 = 1e6
+
+	s = &SQLiteOutput{
+		name: name,
+		tmp:  tmp,
+		db:   db,
+		ops:  make(chan sqliteOp, buf),
+		done: make(chan struct{}),
+		all:  all,
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// sqliteKey is the context key of the SQLite database output.
+type sqliteKey struct{}
+
+// withSQLite returns a context carrying the SQLite database output.
+func withSQLite(ctx context.Context, s *SQLiteOutput) context.Context {
+	return context.WithValue(ctx, sqliteKey{}, s)
+}
+
+// sqliteFrom gets the SQLite database output from a context, or nil if there is none.
+func sqliteFrom(ctx context.Context) *SQLiteOutput {
+	s, _ := ctx.Value(sqliteKey{}).(*SQLiteOutput)
+
+	return s
+}
+
+// quote quotes an SQLite identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// tableName gets the table name of an output file name: the base name without a .csv or format extension, with dashes replaced. Other extensions, e.g. .txt, are kept with the dot replaced.
+func tableName(name string) string {
+	name = filepath.Base(name)
+
+	for _, ext := range []string{"." + CSV, "." + Parquet, "." + SQLite} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// checkSQLite checks that outputs are not encrypted if the SQLite database output is written, i.e. with the sqlite output format or an sqlite sink. The database is written as a plaintext file until it is complete.
+func checkSQLite(c *Crypt, format string, sinks map[string]string) (err error) {
+	if !c.encrypting() {
+		return nil
+	}
+
+	if format == SQLite || hasSink(sinks, SQLite) {
+		return errors.New("the sqlite output format and sqlite sinks cannot be used with encrypted outputs")
+	}
+
+	return nil
+}
+
+// Discard removes the temporary file of the database, unless it was added to the outputs of the run. Discard does nothing if the SQLite database output is nil.
+func (s *SQLiteOutput) Discard() {
+	if s == nil || s.staged {
+		return
+	}
+
+	err := os.Remove(s.tmp)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+// fatal discards the database and exits.
+func (s *SQLiteOutput) fatal(err error) {
+	s.Discard()
+	log.Fatalln(err)
+}
+
+// run creates tables and inserts rows in batched transactions until the operation channel is closed.
+func (s *SQLiteOutput) run() {
+	var tx *sql.Tx
+	var n int
+
+	// insert statements of the current transaction by table
+	stmts := make(map[*sqliteTable]*sql.Stmt)
+
+	commit := func() {
+		if tx == nil {
+			return
+		}
+
+		err := tx.Commit()
+		if err != nil {
+			s.fatal(err)
+		}
+
+		tx = nil
+		n = 0
+		stmts = make(map[*sqliteTable]*sql.Stmt)
+	}
+
+	for op := range s.ops {
+		// create table
+		if op.row == nil {
+			commit()
+
+			cols := make([]string, len(op.t.header))
+			params := make([]string, len(op.t.header))
+
+			for i, c := range op.t.header {
+				cols[i] = quote(c) + " TEXT"
+				params[i] = "?"
+			}
+
+			_, err := s.db.Exec("DROP TABLE IF EXISTS " + quote(op.t.name) + "; CREATE TABLE " + quote(op.t.name) + " (" + strings.Join(cols, ", ") + ")")
+			if err != nil {
+				s.fatal(err)
+			}
+
+			op.t.stmt, err = s.db.Prepare("INSERT INTO " + quote(op.t.name) + " VALUES (" + strings.Join(params, ", ") + ")")
+			if err != nil {
+				s.fatal(err)
+			}
+
+			continue
+		}
+
+		// insert row
+		if tx == nil {
+			var err error
+
+			tx, err = s.db.Begin()
+			if err != nil {
+				s.fatal(err)
+			}
+		}
+
+		args := make([]interface{}, len(op.t.header))
+		for i := range args {
+			if i < len(op.row) {
+				args[i] = op.row[i]
+			}
+		}
+
+		stmt, ok := stmts[op.t]
+		if !ok {
+			stmt = tx.Stmt(op.t.stmt)
+			stmts[op.t] = stmt
+		}
+
+		_, err := stmt.Exec(args...)
+		if err != nil {
+			s.fatal(err)
+		}
+
+		s.rows++
+		n++
+		if n >= sqliteBatch {
+			commit()
+		}
+	}
+
+	commit()
+
+	close(s.done)
+}
+
+// sqliteRows writes rows to a table of the SQLite database output.
+type sqliteRows struct {
+	s *SQLiteOutput
+	t *sqliteTable
+}
+
+// table creates a table of the SQLite database output with a header. The table replaces a table of the same name.
+func (s *SQLiteOutput) table(name string, h []string) *sqliteRows {
+	t := &sqliteTable{name: tableName(name), header: append([]string{}, h...)}
+
+	s.tables = append(s.tables, t)
+	s.ops <- sqliteOp{t: t}
+
+	return &sqliteRows{s: s, t: t}
+}
+
+// Write inserts a row.
+func (r *sqliteRows) Write(l []string) error {
+	r.s.ops <- sqliteOp{t: r.t, row: append([]string{}, l...)}
+
+	return nil
+}
+
+// Close does nothing. Rows are inserted by the time the SQLite database output is closed.
+func (r *sqliteRows) Close() error {
+	return nil
+}
+
+// TableSink writes rows to a table of the SQLite database output.
+// The row count and a SHA-256 checksum of the CSV encoding of the rows are added to the run manifest, if any, as an output named after the database file and table.
+type TableSink struct {
+	ctx     context.Context
+	db      *SQLiteOutput
+	name    string
+	rows    *sqliteRows
+	sum     checksum
+	csv     *csv.Writer
+	counter int64
+}
+
+// Sink creates a sink of an output table of the SQLite database output, named after an output category or file.
+func (s *SQLiteOutput) Sink(ctx context.Context, name string) *TableSink {
+	return &TableSink{ctx: ctx, db: s, name: name}
+}
+
+// Open creates the table.
+func (s *TableSink) Open(h []string) error {
+	s.rows = s.db.table(s.name, h)
+	s.sum = sha256.New()
+	s.csv = csv.NewWriter(s.sum)
+
+	return nil
+}
+
+// Write inserts a row.
+func (s *TableSink) Write(l []string) error {
+	atomic.AddInt64(&s.counter, 1)
+
+	err := s.rows.Write(l)
+	if err != nil {
+		return err
+	}
+
+	return s.csv.Write(l)
+}
+
+// Close completes the checksum of the rows.
+func (s *TableSink) Close() (err error) {
+	s.csv.Flush()
+
+	err = s.csv.Error()
+	if err != nil {
+		return err
+	}
+
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	manifestFrom(s.ctx).AddOutput(outputsFrom(s.ctx).Rel(s.db.name)+"#"+s.rows.t.name, atomic.LoadInt64(&s.counter), s.sum.Sum(nil))
+
+	return nil
+}
+
+// teeRows writes rows using two row writers.
+type teeRows struct {
+	a rowWriter
+	b rowWriter
+}
+
+// Write writes a row using both row writers.
+func (t teeRows) Write(l []string) error {
+	err := t.a.Write(l)
+	if err != nil {
+		return err
+	}
+
+	return t.b.Write(l)
+}
+
+// Close closes both row writers.
+func (t teeRows) Close() error {
+	err := t.a.Close()
+	if err != nil {
+		return err
+	}
+
+	return t.b.Close()
+}
+
+// metadata saves the run manifest to the metadata table as key and value pairs of the top level manifest fields. String values are unquoted; other values are JSON.
+func (s *SQLiteOutput) metadata(m *Manifest) (err error) {
+	m.Lock()
+	j, err := json.Marshal(m)
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(j, &fields)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DROP TABLE IF EXISTS " + quote(MetadataTable) + "; CREATE TABLE " + quote(MetadataTable) + " (key TEXT PRIMARY KEY, value TEXT)")
+	if err != nil {
+		return err
+	}
+
+	for k, v := range fields {
+		value := string(v)
+
+		var str string
+		if json.Unmarshal(v, &str) == nil {
+			value = str
+		}
+
+		_, err := s.db.Exec("INSERT INTO "+quote(MetadataTable)+" VALUES (?, ?)", k, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec("INSERT INTO "+quote(MetadataTable)+" VALUES (?, ?)", "manifest", string(j))
+
+	return err
+}
+
+// index indexes the MRN, AccessionNumber, and ResultDate columns of each table, if present.
+func (s *SQLiteOutput) index() (err error) {
+	for _, t := range s.tables {
+		colNames := headerParse(t.header)
+
+		for _, c := range SQLiteIndexes {
+			if _, ok := colNames[c]; !ok {
+				continue
+			}
+
+			_, err := s.db.Exec("CREATE INDEX " + quote(t.name+"_"+c) + " ON " + quote(t.name) + " (" + quote(c) + ")")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close inserts remaining rows, indexes tables, saves the run manifest to the metadata table, and closes the database.
+// The database is added to the outputs of the run, or its temporary file is removed if the context is cancelled or closing fails.
+// The row count of all tables and the SHA-256 checksum of the database file are added to the run manifest. The manifest saved in the database does not include them.
+func (s *SQLiteOutput) Close(ctx context.Context, m *Manifest) (err error) {
+	close(s.ops)
+	<-s.done
+
+	defer s.Discard()
+
+	if ctx.Err() != nil {
+		log.Println("run cancelled, discarding", s.name)
+
+		return s.db.Close()
+	}
+
+	err = s.index()
+	if err != nil {
+		s.db.Close()
+		return err
+	}
+
+	m.Finish(true)
+
+	err = s.metadata(m)
+	if err != nil {
+		s.db.Close()
+		return err
+	}
+
+	err = s.db.Close()
+	if err != nil {
+		return err
+	}
+
+	return s.commit(ctx, m)
+}
+
+// commit adds the temporary file of the closed database to the outputs of the run.
+func (s *SQLiteOutput) commit(ctx context.Context, m *Manifest) (err error) {
+	f, err := os.Open(s.tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+
+	_, err = io.Copy(sum, f)
+	if err != nil {
+		return err
+	}
+
+	m.AddOutput(outputsFrom(ctx).Rel(s.name), s.rows, sum.Sum(nil))
+
+	err = outputsFrom(ctx).add(s.tmp, s.name)
+	if err != nil {
+		return err
+	}
+
+	s.staged = true
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/google/go-cmp/cmp"
+)
+
+// helperSQLiteQuery queries an SQLite database for a column of strings.
+func helperSQLiteQuery(t *testing.T, name string, query string, args ...interface{}) (values []string) {
+	db, err := sql.Open("sqlite", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v sql.NullString
+
+		err := rows.Scan(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values = append(values, v.String)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return values
+}
+
+func TestTableName(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"Remove format extensions": {input: "results.sqlite", want: "results"},
+		"Remove CSV extensions":    {input: "pdl1-unique-strings.csv", want: "pdl1_unique_strings"},
+		"Keep other extensions":    {input: "new-ids.txt", want: "new_ids_txt"},
+		"Remove directories":       {input: filepath.Join("out", "results-increment.csv"), want: "results_increment"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, tableName(tc.input))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestSQLiteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManifest("test")
+
+	ctx := withManifest(withOutputs(context.Background(), o), m)
+
+	db, err := NewSQLiteOutput(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = withSQLite(ctx, db)
+
+	w := NewSink(withOutputFormat(ctx, SQLite), "results")
+
+	err = w.Open([]string{"MRN", "ResultDate", "Value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range [][]string{
+		{"1000000001", "2020-11-15", "10.5"},
+		{"1000000002", "2020-11-16", "Negative"},
+		{"1000000003"},
+	} {
+		err := w.Write(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := File(ctx, "new-ids.txt", []string{"identifier"})
+
+	err = u.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = u.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Close(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := o.Path(SQLiteFile)
+
+	tests := map[string]struct {
+		query string
+		want  []string
+	}{
+		"Write rows to tables":               {query: "SELECT Value FROM results ORDER BY MRN", want: []string{"10.5", "Negative", ""}},
+		"Write files to tables as well":      {query: "SELECT identifier FROM new_ids_txt", want: []string{"1000000001"}},
+		"Index identifier and date columns":  {query: "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'results' ORDER BY name", want: []string{"results_MRN", "results_ResultDate"}},
+		"Save the run manifest as metadata":  {query: "SELECT value FROM metadata WHERE key = 'run'", want: []string{"test"}},
+		"Save the complete manifest as JSON": {query: "SELECT json_extract(value, '$.complete') FROM metadata WHERE key = 'manifest'", want: []string{"1"}},
+	}
+
+	for test, tc := range tests {
+		tc := tc
+
+		t.Run(test, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, helperSQLiteQuery(t, name, tc.query))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Add database and tables to manifest relative to the output directory", func(t *testing.T) {
+		diff := cmp.Diff([]int64{4, 3}, []int64{m.Outputs[SQLiteFile].Rows, m.Outputs[SQLiteFile+"#results"].Rows})
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write new-ids.txt as a file", func(t *testing.T) {
+		diff := cmp.Diff([]string{"1000000001"}, helperColumn(t, o.Path("new-ids.txt"), 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Build database in the output directory", func(t *testing.T) {
+		diff := cmp.Diff(dir, filepath.Dir(db.tmp))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+
+		_, err := os.Stat(db.tmp)
+		if !os.IsNotExist(err) {
+			t.Fatal("temporary database file not renamed")
+		}
+	})
+}
+
+func TestCheckSQLite(t *testing.T) {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Crypt{recipients: []age.Recipient{i.Recipient()}}
+
+	tests := map[string]struct {
+		c      *Crypt
+		format string
+		sinks  map[string]string
+		fails  bool
+	}{
+		"Unencrypted SQLite output format":    {format: SQLite},
+		"Encrypted SQLite output format":      {c: c, format: SQLite, fails: true},
+		"Encrypted SQLite sink":               {c: c, format: CSV, sinks: map[string]string{"pdl1": SQLite}, fails: true},
+		"Encrypted outputs without SQLite":    {c: c, format: Parquet, sinks: map[string]string{"pdl1": JSONL}},
+		"Decrypted inputs with SQLite output": {c: &Crypt{}, format: SQLite},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			err := checkSQLite(tc.c, tc.format, tc.sinks)
+
+			diff := cmp.Diff(tc.fails, err != nil)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestSQLiteOutputCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ih-abstract-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := NewOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(withOutputs(context.Background(), o))
+
+	db, err := NewSQLiteOutput(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.Sink(ctx, "results")
+
+	err = w.Open([]string{"MRN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Close(ctx, NewManifest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Remove database of cancelled run", func(t *testing.T) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff := cmp.Diff(0, len(files))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestFullSQLite(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	sqlite := SQLite
+
+	f := helperFlags()
+	f.outputFormat = &sqlite
+
+	// results of other formats are diffed by the next run using a state database
+	state := "test-sqlite-state.db"
+	f.state = &state
+
+	defer os.Remove(state)
+
+	innerTest(f, TestFile)
+
+	j, err := ioutil.ReadFile(ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(j, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"Write results table":         {input: "results", want: SQLiteFile + "#results"},
+		"Write report tables":         {input: "pdl1", want: SQLiteFile + "#pdl1"},
+		"Write unique strings tables": {input: "pdl1_unique_strings", want: "pdl1-unique-strings.csv"},
+		"Write other file tables":     {input: "msi_unique_strings", want: "msi-unique-strings.csv"},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			want := strconv.FormatInt(m.Outputs[tc.want].Rows, 10)
+
+			diff := cmp.Diff([]string{want}, helperSQLiteQuery(t, SQLiteFile, "SELECT count(*) FROM "+quote(tc.input)))
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+
+	t.Run("Write no result files", func(t *testing.T) {
+		if _, err := os.Stat("results.csv"); !os.IsNotExist(err) {
+			t.Fatal("results.csv written", err)
+		}
+	})
+}
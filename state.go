@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// recordsBucket is the state database bucket mapping record hashes to the run ID in which the record was first seen.
+var recordsBucket = []byte("records")
+
+// runsBucket is the state database bucket mapping run IDs to run completion times.
+var runsBucket = []byte("runs")
+
+// State is a persistent on-disk store of record hashes, the run in which each record was first seen, a run ledger of record events, and quality assurance reviews of unique strings.
+// Hashes of records added, events recorded, and unique strings seen during a run are held in memory until the run is committed.
+type State struct {
+	db      *bolt.DB
+	run     string
+	pending Store
+	events  []event
+	seen    map[string](struct{})
+	sync.Mutex
+}
+
+// OpenState opens or creates a state database for a run.
+func OpenState(name string, run string) (s *State, err error) {
+	db, err := bolt.Open(name, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{recordsBucket, runsBucket, ledgerBuckets["mrn"], ledgerBuckets["accession"], reviewBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s = &State{
+		db:      db,
+		run:     run,
+		pending: make(Store),
+		seen:    make(map[string](struct{})),
+	}
+
+	return s, nil
+}
+
+// OpenStateReadOnly opens an existing state database for reading only, e.g. to query the run ledger or reviews.
+// The database is opened with a shared lock and buckets are not created; missing buckets are read as empty.
+func OpenStateReadOnly(name string) (s *State, err error) {
+	db, err := bolt.Open(name, 0o600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	s = &State{
+		db:      db,
+		pending: make(Store),
+		seen:    make(map[string](struct{})),
+	}
+
+	return s, nil
+}
+
+// Close closes the state database.
+func (s *State) Close() error {
+	return s.db.Close()
+}
+
+// Add adds a record to be saved when the run is committed.
+func (s *State) Add(l *[]string) (err error) {
+	hash, err := hash(l)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.pending[hash] = struct{}{}
+	s.Unlock()
+
+	return nil
+}
+
+// Check checks that a record exists in the state database or was added during the run.
+func (s *State) Check(l *[]string) (exists bool, err error) {
+	hash, err := hash(l)
+	if err != nil {
+		return false, err
+	}
+
+	s.Lock()
+	_, exists = s.pending[hash]
+	s.Unlock()
+
+	if exists {
+		return true, nil
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(recordsBucket).Get(hash[:]) != nil
+		return nil
+	})
+
+	return exists, err
+}
+
+// commitRecords saves the record hashes added during the run that do not already exist, preserving the run in which each record was first seen.
+func (s *State) commitRecords(tx *bolt.Tx) (added int64, err error) {
+	run := []byte(s.run)
+
+	b := tx.Bucket(recordsBucket)
+
+	for h := range s.pending {
+		if b.Get(h[:]) != nil {
+			continue
+		}
+
+		err := b.Put(h[:], run)
+		if err != nil {
+			return added, err
+		}
+
+		added++
+	}
+
+	return added, nil
+}
+
+// Import adds the records of an existing output file, read from the file, to be saved when the run is committed.
+func (s *State) Import(o *Old) {
+	log.Println("importing file", o.name, "to state database")
+
+	var counter int64
+
+	_, err := o.each(func(seq uint64, l []string) error {
+		h, err := o.hash(l)
+		if err != nil {
+			return err
+		}
+
+		s.Lock()
+		s.pending[h] = struct{}{}
+		s.Unlock()
+
+		counter++
+
+		return nil
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Println("total imported:", counter, "records")
+}
+
+// Commit saves the records added, events recorded, and unique strings seen during a successful run to the state database in a single transaction, so that a failed commit leaves the state database unchanged.
+func (s *State) Commit() (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var added, nReviews int64
+
+	nEvents := len(s.events)
+
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		added, err = s.commitRecords(tx)
+		if err != nil {
+			return err
+		}
+
+		err = s.commitEvents(tx)
+		if err != nil {
+			return err
+		}
+
+		nReviews, err = s.commitReviews(tx)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(runsBucket).Put([]byte(s.run), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.pending = make(Store)
+	s.events = nil
+	s.seen = make(map[string](struct{}))
+
+	log.Println("committed run", s.run, "to state database:", added, "new records,", nEvents, "ledger events,", nReviews, "strings pending review")
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func helperStateNew(s *State) (lines int64) {
+	in := helperTestReader(TestFile)
+
+	var buf int64 = 2e7
+	out := make(chan []string, buf)
+	done := make(chan struct{})
+
+	New(context.Background(), s, nil, s, helperCorrectHeader(), Identity{}, in, out, done)
+
+	for range out {
+	}
+
+	<-done
+
+	return helperCsvLines("new-ids.txt")
+}
+
+func TestState(t *testing.T) {
+	name := "test-state.db"
+
+	defer os.Remove(name)
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	s, err := OpenState(name, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := TestFileOld
+
+	s.Import(LoadOld(context.Background(), old, helperCorrectHeader(), Identity{}, nil, 0))
+
+	t.Run("Detect new data using state database", func(t *testing.T) {
+		diff := cmp.Diff(int64(11), helperStateNew(s))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("No new data when records repeat within a run", func(t *testing.T) {
+		diff := cmp.Diff(int64(1), helperStateNew(s))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	err = s.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenState(name, "run2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	t.Run("No new data after commit", func(t *testing.T) {
+		diff := cmp.Diff(int64(1), helperStateNew(s))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
+
+func TestStateUncommitted(t *testing.T) {
+	name := "test-state-uncommitted.db"
+
+	defer os.Remove(name)
+	defer os.Remove("new-ids.txt")
+	defer os.Remove("new-ids.csv")
+
+	s, err := OpenState(name, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := TestFileOld
+
+	s.Import(LoadOld(context.Background(), old, helperCorrectHeader(), Identity{}, nil, 0))
+
+	helperStateNew(s)
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenState(name, "run2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	t.Run("Discard imported and added records of an uncommitted run", func(t *testing.T) {
+		diff := cmp.Diff(int64(12), helperStateNew(s))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
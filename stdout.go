@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Stdout is an output category streamed to standard output instead of being written to a file, so that outputs can be piped to other tools. Logs are written to standard error.
+type Stdout struct {
+	category string
+	w        io.Writer
+	streamed bool
+	sync.Mutex
+}
+
+// NewStdout creates an output category streamed to a writer, usually standard output.
+func NewStdout(category string, w io.Writer) *Stdout {
+	return &Stdout{category: category, w: w}
+}
+
+// stdoutKey is the context key of the output category streamed to standard output.
+type stdoutKey struct{}
+
+// withStdout returns a context carrying the output category streamed to standard output.
+func withStdout(ctx context.Context, s *Stdout) context.Context {
+	return context.WithValue(ctx, stdoutKey{}, s)
+}
+
+// stdoutFrom gets the output category streamed to standard output from a context, or nil if there is none.
+func stdoutFrom(ctx context.Context) *Stdout {
+	s, _ := ctx.Value(stdoutKey{}).(*Stdout)
+
+	return s
+}
+
+// match reports whether an output category is the category streamed to standard output.
+func (s *Stdout) match(category string) bool {
+	if s == nil {
+		return false
+	}
+
+	return category == s.category
+}
+
+// StdoutSink streams rows of an output file to standard output in an output format.
+// Rows are encrypted if outputs are encrypted. Output that has been streamed cannot be discarded if the run is cancelled.
+// Otherwise, the row count and SHA-256 checksum of the stream are added to the run manifest, if any, as an output named after the output file with a 'stdout:' prefix.
+type StdoutSink struct {
+	ctx     context.Context
+	stdout  *Stdout
+	name    string
+	format  string
+	out     *bufio.Writer
+	enc     io.WriteCloser
+	sum     checksum
+	rows    rowWriter
+	counter int64
+}
+
+// Sink creates a sink of an output file streamed to standard output in an output format.
+func (s *Stdout) Sink(ctx context.Context, name string, format string) *StdoutSink {
+	return &StdoutSink{
+		ctx:    ctx,
+		stdout: s,
+		name:   "stdout:" + name,
+		format: format,
+	}
+}
+
+// Open writes the header. An output category can be streamed only once.
+func (s *StdoutSink) Open(h []string) (err error) {
+	s.stdout.Lock()
+	streamed := s.stdout.streamed
+	s.stdout.streamed = true
+	s.stdout.Unlock()
+
+	if streamed {
+		return errors.New(strings.Join([]string{"output category", s.stdout.category, "is streamed to stdout more than once"}, " "))
+	}
+
+	s.out = bufio.NewWriter(s.stdout.w)
+	s.sum = sha256.New()
+
+	s.enc, err = cryptFrom(s.ctx).Encrypt(io.MultiWriter(s.out, s.sum))
+	if err != nil {
+		return err
+	}
+
+	s.rows, err = newRows(s.enc, h, s.format)
+
+	return err
+}
+
+// Write streams a row.
+func (s *StdoutSink) Write(l []string) error {
+	atomic.AddInt64(&s.counter, 1)
+
+	return s.rows.Write(l)
+}
+
+// Close flushes the stream.
+func (s *StdoutSink) Close() (err error) {
+	err = s.rows.Close()
+	if err != nil {
+		return errors.New(s.name + ": " + err.Error())
+	}
+
+	err = s.enc.Close()
+	if err != nil {
+		return err
+	}
+
+	err = s.out.Flush()
+	if err != nil {
+		return err
+	}
+
+	if s.ctx.Err() != nil {
+		log.Println("run cancelled, output streamed to stdout is incomplete")
+		return nil
+	}
+
+	manifestFrom(s.ctx).AddOutput(s.name, atomic.LoadInt64(&s.counter), s.sum.Sum(nil))
+
+	return nil
+}
+
+// Check checks that the output category was streamed to standard output, so that a category that the run does not write is not mistaken for an empty one. Check does nothing if the output category is nil.
+func (s *Stdout) Check() (err error) {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.streamed {
+		return errors.New(strings.Join([]string{"output category", s.category, "was not written by this run"}, " "))
+	}
+
+	return nil
+}
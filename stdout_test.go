@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStdout(t *testing.T) {
+	var buf bytes.Buffer
+
+	s := NewStdout("pdl1", &buf)
+	m := NewManifest("test")
+
+	ctx := withManifest(withStdout(context.Background(), s), m)
+
+	t.Run("Fail if the category is not written", func(t *testing.T) {
+		if s.Check() == nil {
+			t.Fatal("no error for unwritten category")
+		}
+	})
+
+	w := NewSink(withOutputFormat(ctx, JSONL), "pdl1")
+
+	err := w.Open([]string{"MRN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Write([]string{"1000000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		input interface{}
+		want  interface{}
+	}{
+		"Stream category in output format": {input: buf.String(), want: `{"MRN":"1000000001"}` + "\n"},
+		"Add stream to manifest":           {input: m.Outputs["stdout:pdl1.jsonl"].Rows, want: int64(1)},
+		"Pass check of written category":   {input: s.Check(), want: nil},
+		"Match category by name":           {input: s.match("pdl1"), want: true},
+		"Match category by full name only": {input: s.match("pdl1-unique-strings"), want: false},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			diff := cmp.Diff(tc.want, tc.input)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFullStdout(t *testing.T) {
+	cleanupTestFull()
+	defer cleanupTestFull()
+
+	out, err := ioutil.TempFile("", "ih-abstract-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+
+	jsonl := JSONL
+	category := "pdl1"
+
+	f := helperFlags()
+	f.outputFormat = &jsonl
+
+	// results of other formats are diffed by the next run using a state database
+	state := "test-stdout-state.db"
+	f.state = &state
+
+	defer os.Remove(state)
+	f.stdout = &category
+
+	for _, name := range []string{"results", "results-increment", "msi", "cpd", "wbc"} {
+		defer os.Remove(name + ".jsonl")
+	}
+
+	stdout := os.Stdout
+	os.Stdout = out
+	innerTest(f, TestFile)
+	os.Stdout = stdout
+
+	out.Close()
+
+	t.Run("Stream category to stdout", func(t *testing.T) {
+		b, err := ioutil.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			var o map[string]string
+
+			err := json.Unmarshal(s.Bytes(), &o)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n++
+		}
+
+		j, err := ioutil.ReadFile(ManifestFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Manifest
+
+		err = json.Unmarshal(j, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n == 0 {
+			t.Fatal("no rows streamed")
+		}
+
+		diff := cmp.Diff(m.Outputs["stdout:pdl1.jsonl"].Rows, int64(n))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Write no file of streamed category", func(t *testing.T) {
+		if _, err := os.Stat("pdl1.jsonl"); !os.IsNotExist(err) {
+			t.Fatal("pdl1.jsonl written", err)
+		}
+	})
+
+	t.Run("Write other categories to files", func(t *testing.T) {
+		if _, err := os.Stat("results.jsonl"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
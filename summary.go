@@ -0,0 +1,125 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SummaryHeader is the header of the new record summary output file, new-ids.csv.
+var SummaryHeader = []string{"identifier", "categories", "new-records", "first-result-date", "last-result-date", "accession-numbers"}
+
+// summary summarizes the new records of a person-instance.
+type summary struct {
+	categories map[string]struct{}
+	records    int64
+	first      string
+	last       string
+	accessions map[string]struct{}
+}
+
+// Summaries summarizes why each person-instance has new records: triggering report categories, the number of new records, the earliest and latest new result dates, and the accession numbers involved.
+type Summaries struct {
+	colNames map[string]int
+	pat      map[string](*regexp.Regexp)
+	classify bool
+	s        map[string](*summary)
+}
+
+// NewSummaries creates new record summaries for input data with a given header.
+// Records are classified by report category if the header contains the columns used for filtering. Otherwise, the category is "other".
+func NewSummaries(header []string) (s *Summaries) {
+	s = &Summaries{
+		colNames: headerParse(header),
+		pat:      patterns(),
+		classify: true,
+		s:        make(map[string](*summary)),
+	}
+
+	for _, col := range []string{"OrderTypeMnemonic", "TestTypeMnemonic", "Value"} {
+		if _, ok := s.colNames[col]; !ok {
+			s.classify = false
+		}
+	}
+
+	return s
+}
+
+// column gets the value of a named column of a record, or "" if the column does not exist.
+func column(l []string, colNames map[string]int, name string) string {
+	i, ok := colNames[name]
+	if !ok || i >= len(l) {
+		return ""
+	}
+
+	return l[i]
+}
+
+// Add adds a new record of a person-instance to the summaries.
+func (s *Summaries) Add(id string, l []string) {
+	x, ok := s.s[id]
+	if !ok {
+		x = &summary{
+			categories: make(map[string]struct{}),
+			accessions: make(map[string]struct{}),
+		}
+		s.s[id] = x
+	}
+
+	category := ""
+	if s.classify {
+		category = classify(l, s.colNames, s.pat)
+	}
+
+	if category == "" {
+		category = "other"
+	}
+
+	x.categories[category] = struct{}{}
+	x.records++
+
+	// canonical dates sort lexically
+	date := canonical(column(l, s.colNames, "ResultDate"))
+	if date != "" && (x.first == "" || date < x.first) {
+		x.first = date
+	}
+	if date > x.last {
+		x.last = date
+	}
+
+	accession := column(l, s.colNames, "AccessionNumber")
+	if accession != "" {
+		x.accessions[accession] = struct{}{}
+	}
+}
+
+// join joins the sorted keys of a set.
+func join(m map[string]struct{}) string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return strings.Join(keys, ";")
+}
+
+// Row creates a new record summary output row for a person-instance.
+func (s *Summaries) Row(id string) []string {
+	x, ok := s.s[id]
+	if !ok {
+		return nil
+	}
+
+	return []string{
+		id,
+		join(x.categories),
+		strconv.FormatInt(x.records, 10),
+		x.first,
+		x.last,
+		join(x.accessions),
+	}
+}
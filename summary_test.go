@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSummaries(t *testing.T) {
+	header := helperCorrectHeader()
+
+	s := NewSummaries(header)
+
+	cpd := []string{"1000000008", "UID", "1111111111", "ZZZ, ZZZ", "1950006-16 00:00:00.000", "M", "2020-11-15 05:28:00.000", "GL", "00000111111112", "1111111111", "1111111111", "Solid Tumor NGS Report", "1111111111111", "111111", "2014-11-16 05:37:58.000", "CPD report"}
+	pdl1 := []string{"1000000008", "UID", "1111111111", "ZZZ, ZZZ", "1950006-16 00:00:00.000", "M", "2020-11-15 05:28:00.000", "GL", "00000111111111", "1111111111", "1111111111", "Surgical Pathology Report", "1111111111111", "111111", "2014-11-15 05:37:58.000", "PD-L1 Tumor proportion score: 5%"}
+
+	s.Add("1000000008", cpd)
+	s.Add("1000000008", pdl1)
+
+	t.Run("Summarize new records of a person-instance", func(t *testing.T) {
+		want := []string{"1000000008", "cpd;pdl1", "2", "2014-11-15 05:37:58.000", "2014-11-16 05:37:58.000", "00000111111111;00000111111112"}
+
+		diff := cmp.Diff(want, s.Row("1000000008"))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Classify as other without filtering columns", func(t *testing.T) {
+		s := NewSummaries([]string{"MRN", "Value"})
+		s.Add("1", []string{"1", "PD-L1"})
+
+		diff := cmp.Diff([]string{"1", "other", "1", "", "", ""}, s.Row("1"))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+}
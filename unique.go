@@ -1,33 +1,78 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"strings"
 )
 
-// prevUnq adds previously identified unique strings from an existing output file to a hash map.
-func prevUnq(f string) (r *Records) {
+// UniqueHeader is the header of unique string outputs.
+var UniqueHeader = []string{"unique-result"}
+
+// UniqueReviewHeader is the header of unique string outputs with a state database: the canonical value of strings mapped by quality assurance review follows each string.
+var UniqueReviewHeader = []string{"unique-result", "canonical"}
+
+// prevUnq adds previously identified unique strings from an existing output file to a hash map. Strings are identified by the first column, so that outputs with or without canonical values compare.
+// Compressed output files are read as well. If outputs are encrypted, an existing encrypted output file is preferred.
+func prevUnq(ctx context.Context, f string) (r *Records) {
 	var records Records
 	records.Store = make(Store)
 
 	r = &records
 
-	if _, err := os.Stat(f); err == nil {
-		log.Println("reading patterns from existing records file", f)
-		r = Existing(&f)
-	} else {
+	prev := previousOutput(ctx, f)
+	if prev == "" {
+		log.Println("no previous run, skipping diff of", f)
+		return r
+	}
+
+	f = prev
+
+	if _, err := os.Stat(f); err != nil {
 		log.Println("existing records file", f, "does not exist, skipping diff")
+		return r
+	}
+
+	log.Println("reading patterns from existing records file", f)
+
+	in, err := openInput(ctx, f)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	defer in.Close()
+
+	rows := readCSV(ctx, in)
+
+	go func() {
+		<-rows.done
+	}()
+
+	for l := range rows.out {
+		if len(l) == 0 {
+			continue
+		}
+
+		i := l[:1]
+
+		err := r.Add(&i)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	log.Println("total:", len(r.Store), "patterns")
 
 	return r
 }
 
 // DiffUnq identifies unique strings from an input stream and compares the unique strings to an existing output file. The function returns 1) unique strings and 2) new strings compared to the existing output file.
-func DiffUnq(in chan []string, name string) (channels map[string](chan []string), done chan struct{}) {
+// If a state database is provided, new strings are instead unique strings that have not been reviewed, and unique strings are saved as pending review when the run is committed. Strings are output with the columns of UniqueReviewHeader: the canonical value of a string mapped by review follows the string.
+// If a Scrubber is provided, strings are scrubbed of PHI once input is exhausted, before comparison.
+func DiffUnq(ctx context.Context, state *State, scrubber *Scrubber, in chan []string, name string) (channels map[string](chan []string), done chan struct{}) {
 	done = make(chan struct{})
 
-	var buf int64 = 1e7
+	var buf int64 = 1e5
 
 	// channels contains communication of rows
 	// between goroutines processing data
@@ -43,52 +88,116 @@ func DiffUnq(in chan []string, name string) (channels map[string](chan []string)
 	channels[unqRecordsNameNew] = make(chan []string, buf)
 
 	// read previous output
-	f := strings.Join([]string{name, "-unique-strings.csv"}, "")
-	prevResults := prevUnq(f)
-
 	var records Records
 	records.Store = make(Store)
-	currentResults := &records
+	prevResults := &records
 
-	go func() {
-		for l := range in { // for each slice
-			for _, s := range l { // each string of slice
+	if state == nil {
+		f := strings.Join([]string{name, "-unique-strings.csv"}, "")
+		prevResults = prevUnq(ctx, f)
+	}
+
+	var current Records
+	current.Store = make(Store)
+	currentResults := &current
+
+	// diffString compares a string to current and previous records
+	diffString := func(s string) {
+		i := []string{s}
 
-				i := []string{s}
+		existsCurrent, err := currentResults.Check(&i)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// string does not exist in current records
+		if !existsCurrent {
+			err = currentResults.Add(&i)
+			if err != nil {
+				log.Fatalln(err)
+			}
 
-				existsCurrent, err := currentResults.Check(&i)
+			// string has not been reviewed
+			if state != nil {
+				state.Seen(name, s)
+
+				r, err := state.Review(name, s)
 				if err != nil {
 					log.Fatalln(err)
 				}
 
-				// string does not exist in current records
-				if !existsCurrent {
-					err = currentResults.Add(&i)
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					channels[unqRecordsName] <- []string{s}
+				// canonical value of a string mapped by review
+				var c string
+				if r.Status == Mapped {
+					c = r.Canonical
 				}
 
-				// string does not exist in previous records
-				existsPrev, err := prevResults.Check(&i)
-				if err != nil {
-					log.Fatalln(err)
+				channels[unqRecordsName] <- []string{s, c}
+
+				if r.Status == "" || r.Status == Pending {
+					log.Println("Unreviewed string:", s)
+					channels[unqRecordsNameNew] <- []string{s, c}
 				}
+			} else {
+				channels[unqRecordsName] <- []string{s}
+			}
+		}
+
+		if state != nil {
+			return
+		}
+
+		// string does not exist in previous records
+		existsPrev, err := prevResults.Check(&i)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if !existsPrev {
+			err = prevResults.Add(&i)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			log.Println("New string:", s)
+			channels[unqRecordsNameNew] <- []string{s}
+		}
+	}
+
+	go func() {
+		// strings to scrub once all names of the run are known
+		var raw []string
+		rawSeen := make(map[string](struct{}))
 
-				if !existsPrev {
-					err = prevResults.Add(&i)
-					if err != nil {
-						log.Fatalln(err)
-					}
+		for l := range in { // for each slice
+			for _, s := range l { // each string of slice
+				if scrubber == nil {
+					diffString(s)
+					continue
+				}
 
-					log.Println("New string:", s)
-					channels[unqRecordsNameNew] <- []string{s}
+				if _, ok := rawSeen[s]; !ok {
+					rawSeen[s] = struct{}{}
+					raw = append(raw, s)
 				}
 			}
 		}
 
+		var scrubbed int64
+
+		for _, s := range raw {
+			x := scrubber.Scrub(s)
+			if x != s {
+				scrubbed++
+			}
+
+			diffString(x)
+		}
+
+		if scrubber != nil {
+			log.Println("scrubbed PHI from", scrubbed, name, "strings")
+		}
+
 		close(channels[unqRecordsName])
 		close(channels[unqRecordsNameNew])
 		done <- struct{}{}
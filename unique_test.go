@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"os"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -16,7 +18,7 @@ func TestDiffUnq(t *testing.T) {
 		close(in)
 	}()
 
-	channels, done := DiffUnq(in, "test-diff")
+	channels, done := DiffUnq(context.Background(), nil, nil, in, "test-diff")
 
 	<-done
 
@@ -47,3 +49,22 @@ func TestDiffUnq(t *testing.T) {
 		}
 	})
 }
+
+func TestPrevUnqCanonical(t *testing.T) {
+	name := "test-prev-unq-unique-strings.csv"
+
+	err := os.WriteFile(name, []byte("unique-result,canonical\nA,a\nB,\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+
+	r := prevUnq(context.Background(), name)
+
+	for _, s := range []string{"A", "B"} {
+		exists, err := r.Check(&[]string{s})
+		if !exists || err != nil {
+			t.Fatal("failed to read string", s, "of output with canonical values")
+		}
+	}
+}
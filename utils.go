@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/davecgh/go-spew/spew"
@@ -35,7 +39,7 @@ func count(counter *int64, descr string, signal chan struct{}) {
 
 // splitCh splits a []string channel into two channels, sending results from the input channel onto both output channels
 func splitCh(in chan []string) (out1 chan []string, out2 chan []string, done chan struct{}) {
-	var buf int64 = 2e7
+	var buf int64 = 1e5
 
 	out1 = make(chan []string, buf)
 	out2 = make(chan []string, buf)
@@ -54,3 +58,17 @@ func splitCh(in chan []string) (out1 chan []string, out2 chan []string, done cha
 
 	return
 }
+
+// cancelOnSignal cancels a context on receipt of SIGINT or SIGTERM.
+func cancelOnSignal(cancel context.CancelFunc) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		s := <-sig
+		log.Println("received", s, "signal, cancelling")
+
+		signal.Stop(sig)
+		cancel()
+	}()
+}
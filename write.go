@@ -1,93 +1,164 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
+	"io"
 	"log"
-	"os"
 	"strings"
-	"sync/atomic"
 )
 
-// Writer contains a file name, connection, CSV Writer, and a 'done' signal to cleanup the connection.
-type Writer struct {
-	name    string
-	conn    *os.File
-	w       *csv.Writer
-	counter int64
-	done    func()
+// Output formats of result files.
+const (
+	CSV     = "csv"
+	JSONL   = "jsonl"
+	Parquet = "parquet"
+	SQLite  = "sqlite"
+)
+
+// OutputFormats are the supported output formats of result files.
+var OutputFormats = []string{CSV, JSONL, Parquet, SQLite}
+
+// checkOutputFormat checks that an output format is supported.
+func checkOutputFormat(format string) (err error) {
+	for _, f := range OutputFormats {
+		if f == format {
+			return nil
+		}
+	}
+
+	return errors.New(strings.Join([]string{"unknown output format", format, "expected one of", strings.Join(OutputFormats, ", ")}, " "))
 }
 
-// File creates an output CSV write file.
-func File(name string, h []string) (w Writer) {
-	f, err := os.OpenFile(name, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Fatalln(err)
+// outputFormatKey is the context key of the output format of result files.
+type outputFormatKey struct{}
+
+// withOutputFormat returns a context carrying the output format of result files.
+func withOutputFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, outputFormatKey{}, format)
+}
+
+// outputFormatFrom gets the output format of result files from a context, CSV by default.
+func outputFormatFrom(ctx context.Context) string {
+	format, ok := ctx.Value(outputFormatKey{}).(string)
+	if !ok {
+		return CSV
 	}
 
-	c := csv.NewWriter(f)
+	return format
+}
+
+// rowWriter writes the rows of an output file format.
+type rowWriter interface {
+	Write(l []string) error
+	Close() error
+}
+
+// csvRows writes rows as CSV.
+type csvRows struct {
+	w *csv.Writer
+}
+
+// newCSVRows creates a CSV row writer and writes the header.
+func newCSVRows(w io.Writer, h []string) (r *csvRows, err error) {
+	c := csv.NewWriter(w)
 
 	c.UseCRLF = false
 
 	err = c.Write(h)
 	if err != nil {
-		return Writer{}
+		return nil, err
 	}
 
-	w.name = name
-	w.conn = f
-	w.w = c
+	return &csvRows{w: c}, nil
+}
 
-	// done closes the connection
-	done := func() {
-		c.Flush()
+// Write writes a CSV row.
+func (r *csvRows) Write(l []string) error {
+	return r.w.Write(l)
+}
 
-		err := c.Error()
-		if err != nil {
-			log.Fatalln(err)
-		}
+// Close flushes CSV rows.
+func (r *csvRows) Close() error {
+	r.w.Flush()
 
-		f.Close()
-	}
+	return r.w.Error()
+}
 
-	w.done = done
+// newRows creates a row writer of an output format, CSV by default.
+func newRows(w io.Writer, h []string, format string) (r rowWriter, err error) {
+	switch format {
+	case Parquet:
+		return newParquetRows(w, h), nil
+	case JSONL:
+		return newJSONLRows(w, h)
+	default:
+		return newCSVRows(w, h)
+	}
+}
 
-	return w
+// WriteRows writes rows to a Sink, opened with a header and closed once the input is done.
+// If the context is cancelled, remaining input is drained without writing.
+func WriteRows(ctx context.Context, in chan []string, s Sink, h []string, done chan struct{}) {
+	writeRows(ctx, in, s, h, nil, done)
 }
 
-// WriteRows appends strings to a CSV file using a Writer.
-func WriteRows(in chan []string, name string, h []string, done chan struct{}) {
-	w := File(name, h)
+// writeRows writes rows to a Sink, like WriteRows. If column indexes are given, rows are projected to the columns.
+func writeRows(ctx context.Context, in chan []string, s Sink, h []string, cols []int, done chan struct{}) {
+	err := s.Open(h)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	go func() {
 		for l := range in {
-			err := w.w.Write(l)
+			if ctx.Err() != nil {
+				continue
+			}
+
+			if cols != nil {
+				l = project(l, cols)
+			}
+
+			err := s.Write(l)
 			if err != nil {
 				log.Fatalln(err)
 			}
+		}
 
-			atomic.AddInt64(&w.counter, 1)
+		err := s.Close()
+		if err != nil {
+			log.Fatalln(err)
 		}
 
-		w.done()
 		done <- struct{}{}
 	}()
 }
 
-// Write writes results to output CSV files using a common header.
-func Write(h []string, in map[string](chan []string)) (done chan struct{}) {
+// Write writes output categories to the sinks of the context using a common header: files of the output format of the context, CSV by default, unless the configuration file chooses another sink.
+// Outputs with a column projection in the context are written with the projected columns only.
+func Write(ctx context.Context, h []string, in map[string](chan []string)) (done chan struct{}) {
 	done = make(chan struct{})
 
 	nOutputFiles := len(in)
 	signal := make(chan struct{}, nOutputFiles)
 
 	for i, c := range in {
+		s := NewSink(ctx, i)
+
+		p, ok := projectionFrom(ctx, i)
+		if !ok {
+			WriteRows(ctx, c, s, h, signal)
+			continue
+		}
 
-		// filename
-		var fn strings.Builder
-		fn.WriteString(i)
-		fn.WriteString(".csv")
+		ph, cols, err := p.columns(h)
+		if err != nil {
+			log.Fatalln(i+":", err)
+		}
 
-		WriteRows(c, fn.String(), h, signal)
+		writeRows(ctx, c, s, ph, cols, signal)
 	}
 
 	go func() {
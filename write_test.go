@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -13,7 +16,7 @@ func TestRowWriter(t *testing.T) {
 "1000000001","UID",1111111111,"ZZZ, ZZZ",1950006-1600:00:00.000,M,2020-11-1505:28:00.000,GL,00000111111111,1111111111,1111111111,CMV,1111111111111,WBC,2014-11-1505:37:58.000,Test removal on basis of Order
 `)
 
-	r := readCSV(mock)
+	r := readCSV(context.Background(), mock)
 
 	<-r.done
 
@@ -22,7 +25,7 @@ func TestRowWriter(t *testing.T) {
 
 		f := "test-write.csv"
 
-		WriteRows(r.out, f, r.header, writeDone)
+		WriteRows(context.Background(), r.out, NewFileSink(context.Background(), f, CSV, 0o600), r.header, writeDone)
 		defer os.Remove("test-write.csv")
 
 		<-writeDone
@@ -52,7 +55,7 @@ func TestWrite(t *testing.T) {
 
 	t.Run("write CSV file from channel", func(t *testing.T) {
 		defer os.Remove("test-write.csv")
-		done := Write(header, channels)
+		done := Write(context.Background(), header, channels)
 		<-done
 
 		lines := helperCsvLines("test-write.csv")
@@ -63,3 +66,49 @@ func TestWrite(t *testing.T) {
 		}
 	})
 }
+
+func TestWriteCancelled(t *testing.T) {
+	header := []string{"MRN"}
+
+	name := "test-write-cancelled.csv"
+
+	defer os.Remove(name)
+
+	err := ioutil.WriteFile(name, []byte("MRN\n1000000000\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf int64 = 2e7
+	in := make(chan []string, buf)
+
+	channels := make(map[string](chan []string))
+	channels["test-write-cancelled"] = in
+
+	in <- []string{"1000000001"}
+	close(in)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := Write(ctx, header, channels)
+	<-done
+
+	t.Run("Keep previous output of cancelled run", func(t *testing.T) {
+		diff := cmp.Diff([]string{"1000000000"}, helperColumn(t, name, 0))
+		if diff != "" {
+			t.Fatalf(diff)
+		}
+	})
+
+	t.Run("Discard temporary files of cancelled run", func(t *testing.T) {
+		tmp, err := filepath.Glob("." + name + ".tmp-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tmp) > 0 {
+			t.Fatal("temporary files of cancelled run exist:", tmp)
+		}
+	})
+}